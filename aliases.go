@@ -0,0 +1,115 @@
+package readconf
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// WithAlias makes Build accept the given alias in place of key when key
+// itself has not been set. The use of an alias is reported as a warning.
+func (b *Builder) WithAlias(alias, key string) *Builder {
+	if b.hasError() {
+		return b
+	}
+
+	if b.aliases == nil {
+		b.aliases = map[string][]string{}
+	}
+
+	key = normalizeKey(key)
+	b.aliases[key] = append(b.aliases[key], normalizeKey(alias))
+	return b
+}
+
+// WithWarningFunc sets a function to receive non-fatal problems found by
+// Build, such as the use of a deprecated key. Warnings are discarded by
+// default.
+func (b *Builder) WithWarningFunc(f func(key, message string)) *Builder {
+	if b.hasError() {
+		return b
+	}
+
+	b.warn = f
+	return b
+}
+
+func (b *Builder) warnf(key, format string, args ...interface{}) {
+	if b.warn != nil {
+		b.warn(key, fmt.Sprintf(format, args...))
+	}
+}
+
+// Returns the aliases given by the aliases tag of a field. Like the config
+// tag, each alias replaces the last element of the field's path.
+func fieldAliases(path []string, f reflect.StructField) []string {
+	tag, ok := f.Tag.Lookup(_aliasesTag)
+	if !ok || tag == `` {
+		return nil
+	}
+
+	prefix := structKey(path[:len(path)-1])
+
+	var aliases []string
+	for _, alias := range strings.Split(tag, `,`) {
+		alias = normalizeKey(alias)
+		if alias == `` {
+			continue
+		}
+
+		if prefix != `` {
+			alias = prefix + _separator + alias
+		}
+
+		aliases = append(aliases, alias)
+	}
+
+	return aliases
+}
+
+// Copies values set under an alias to the key they stand in for, unless the
+// key has been set itself. Only values given to the builder are considered,
+// so an alias always takes precedence over defaults. An error is returned for
+// each key whose aliases conflict, which is set as if the later ones were not.
+func (b *Builder) applyAliases(values Map, valueOrigins origins, aliases map[string][]string) []error {
+	keys := make([]string, 0, len(aliases))
+	for key := range aliases {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var errs []error
+
+	for _, key := range keys {
+		setKey, setValue, found := key, ``, false
+		if v, ok := b.values.Lookup(key); ok {
+			setValue, found = v, true
+		}
+
+		for _, alias := range aliases[key] {
+			v, ok := b.values.Lookup(alias)
+			if !ok {
+				continue
+			}
+
+			if found && v != setValue {
+				errs = append(errs, &AliasConflictError{Key: setKey, Alias: alias})
+				break
+			}
+
+			b.warnf(alias, `configuration key %s is deprecated, use %s instead`, alias, key)
+
+			if !found {
+				setKey, setValue, found = alias, v, true
+			}
+		}
+
+		if found && setKey != key {
+			values.Set(key, setValue)
+			valueOrigins[key] = append(valueOrigins[key], b.origins[setKey]...)
+		}
+	}
+
+	return errs
+}
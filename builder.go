@@ -7,7 +7,9 @@ import (
 	"reflect"
 	"sort"
 	"strings"
+	"sync"
 
+	ut "github.com/go-playground/universal-translator"
 	"github.com/go-playground/validator/v10"
 )
 
@@ -16,9 +18,23 @@ func NewBuilder() *Builder {
 }
 
 type Builder struct {
-	err      error
-	values   Map
-	validate *validator.Validate
+	err        error
+	values     Map
+	origins    origins
+	strict     bool
+	aggregate  bool
+	variants   map[reflect.Type]map[string]reflect.Type
+	resolvers  map[string]ResolverFunc
+	funcs      map[string]TransformFunc
+	fallback   func(key string) (string, bool)
+	aliases    map[string][]string
+	warn       func(key, message string)
+	validate   *validator.Validate
+	translator ut.Translator
+
+	// The result of the most recent call to Build.
+	mu   sync.Mutex
+	last *buildResult
 }
 
 func (b *Builder) Error() error {
@@ -36,7 +52,7 @@ func (b *Builder) Set(k, v string) *Builder {
 
 	m := Map{}
 	m.Set(k, v)
-	return b.MergeMap(m)
+	return b.mergeMap(m, Source{Kind: SourceMap})
 }
 
 func (b *Builder) WithValidator(v *validator.Validate) *Builder {
@@ -49,151 +65,419 @@ func (b *Builder) WithValidator(v *validator.Validate) *Builder {
 }
 
 func (b *Builder) Build(target interface{}) error {
+	_, err := b.record(b.build(target))
+	return err
+}
+
+// Keeps the given result for Explain and Provenance.
+func (b *Builder) record(res *buildResult, err error) (*buildResult, error) {
+	if res != nil {
+		b.mu.Lock()
+		b.last = res
+		b.mu.Unlock()
+	}
+
+	return res, err
+}
+
+// What Explain, Provenance and Dump report about a call to Build.
+type buildResult struct {
+	keys       []string
+	provenance map[string]Provenance
+}
+
+// Builds the target without modifying the Builder, so that it may be shared
+// between goroutines. The result is returned even if building fails, once the
+// values are known.
+func (b *Builder) build(target interface{}) (*buildResult, error) {
 	if err := validateIsPointerToStruct(target); err != nil {
-		return err
+		return nil, err
 	}
 
 	if b.hasError() {
-		return b.err
+		return nil, b.err
 	}
 
 	values := Map{}
+	valueOrigins := origins{}
+	configValues := Map{}
+	configOrigins := origins{}
 	knownFields := map[string]reflect.Value{}
+	secretKeys := map[string]bool{}
+	namespaces := map[string]string{}
+	fieldIndices := map[string][]int{}
+	requiredIf := map[string]condition{}
+	discriminators := map[string]bool{}
+	var toggles []toggle
+	var variantErrs []variantError
+	targetValue := reflect.ValueOf(target).Elem()
+	targetType := targetValue.Type()
+
+	aliases := make(map[string][]string, len(b.aliases))
+	for key, v := range b.aliases {
+		aliases[key] = append([]string(nil), v...)
+	}
+
+	// DefaultConfig values are kept apart from the default tags, which they
+	// take precedence over, so that they can be consulted for discriminators.
+	// The DefaultConfig of a struct only sets keys of its own fields, so it is
+	// merged before they are walked.
+	mergeDefaultConfig := func(v reflect.Value, key string) {
+		m := defaultConfigValues(v, key)
+
+		source := Source{Kind: SourceDefaultConfig, Name: v.Type().String()}
+		for k, v := range m {
+			configOrigins.add(k, v, source)
+		}
+
+		configValues.Merge(m)
+	}
 
 	// walk fields
-	if err := walkStruct(
-		target,
-		func(path []string, f reflect.StructField, v reflect.Value) (bool, error) {
-			if !v.CanSet() {
-				return false, nil
-			}
+	var walkFields func(p *typePlan, v reflect.Value, prefix planPrefix)
+
+	walkFields = func(p *typePlan, v reflect.Value, prefix planPrefix) {
+		for _, fp := range p.fields {
+			key := prefix.key1(fp.key)
+			index := prefix.index1(fp.field.Index)
+			fv := v.FieldByIndex(fp.field.Index)
+
+			if _, ok := b.variants[fv.Type()]; ok && fp.iface {
+				// Errors are kept until it is known whether the field is
+				// within a disabled section.
+				discriminator, err := b.selectVariant(key, fp.field, fv, values, valueOrigins, configValues)
+				discriminators[discriminator] = true
+				if err != nil {
+					variantErrs = append(variantErrs, variantError{index: index, err: err})
+				}
+			} else if fp.direct {
+				knownFields[key] = fv
+				namespaces[prefix.namespace1(fp.namespace)] = key
+				fieldIndices[key] = index
 
-			if tag, ok := f.Tag.Lookup(_configTag); ok && tag != `` {
-				if tag == `-` {
-					return false, nil
+				if fp.requiredIf != nil {
+					requiredIf[key] = *fp.requiredIf
 				}
 
-				path = append(path[:len(path)-1], normalizeKey(tag))
+				if fp.secret {
+					secretKeys[key] = true
+				}
+
+				for _, alias := range fp.aliases {
+					aliases[key] = append(aliases[key], prefix.key1(alias))
+				}
+
+				if fp.defaultTag != nil {
+					values.Set(key, *fp.defaultTag)
+					valueOrigins.add(key, *fp.defaultTag, Source{Kind: SourceDefaultTag, Name: fp.field.Name})
+				}
 			}
 
-			key := structKey(path)
+			if fp.enabledBy != nil {
+				toggles = append(toggles, toggle{key: key, index: index, condition: *fp.enabledBy})
+			}
 
-			if canUnmarshalDirectly(v) {
-				knownFields[key] = v
+			if fp.defaultConfig {
+				mergeDefaultConfig(fv, key)
+			}
 
-				if tag, ok := f.Tag.Lookup(_defaultTag); ok {
-					values.Set(key, tag)
+			if sv := indirectStruct(fv); fp.iface && sv.Kind() == reflect.Struct {
+				sp := planFor(sv.Type())
+				if sp.defaultConfig {
+					mergeDefaultConfig(sv, key)
 				}
+
+				inner := planPrefix{key: key, index: index, namespace: prefix.namespace1(fp.namespace)}
+				walkFields(sp, sv, inner)
 			}
+		}
+	}
 
-			return true, nil
-		},
-	); err != nil {
-		return err
+	rootPlan := planFor(targetType)
+	if rootPlan.defaultConfig {
+		mergeDefaultConfig(targetValue, ``)
 	}
 
+	walkFields(rootPlan, targetValue, planPrefix{})
+
+	values.Merge(configValues)
+	valueOrigins.merge(configOrigins)
+
 	// walk structs
-	if err := walkStruct(
-		target,
-		func(path []string, f reflect.StructField, v reflect.Value) (bool, error) {
-			if !v.CanSet() {
-				return false, nil
-			}
+	var sections []section
 
-			if tag, ok := f.Tag.Lookup(_configTag); ok && tag != `` {
-				if tag == `-` {
-					return false, nil
-				}
+	var walkStructs func(p *typePlan, v reflect.Value, prefix planPrefix)
 
-				path1 := make([]string, len(path))
-				copy(path1, path)
-				path1[len(path1)-1] = normalizeKey(tag)
-				path = path1
-			}
+	walkStructs = func(p *typePlan, v reflect.Value, prefix planPrefix) {
+		for _, fp := range p.fields {
+			key := prefix.key1(fp.key)
+			fv := v.FieldByIndex(fp.field.Index)
 
-			key := structKey(path)
+			if fp.iface {
+				sv := indirectStruct(fv)
+				if sv.Kind() != reflect.Struct {
+					continue
+				}
 
-			if v.Type().Implements(_defaultConfigType) {
-				if m1 := v.Interface().(DefaultConfig).DefaultConfig(); m1 != nil {
-					m2 := make(Map, len(m1))
-					for k, v := range m1 {
-						if key != "" {
-							k = key + _separator + k
-						}
-						m2[k] = v
-					}
+				sp := planFor(sv.Type())
+				index := prefix.index1(fp.field.Index)
 
-					values.Merge(m2)
+				if sp.section && !fp.field.Anonymous {
+					sections = append(sections, section{key: key, index: index, value: sv})
 				}
+
+				walkStructs(sp, sv, planPrefix{key: key, index: index, namespace: prefix.namespace1(fp.namespace)})
+				continue
 			}
 
-			return true, nil
-		},
-	); err != nil {
-		return err
+			if fp.section {
+				sections = append(sections, section{key: key, index: prefix.index1(fp.field.Index), value: fv})
+			}
+		}
+	}
+
+	if rootPlan.section {
+		sections = append(sections, section{key: ``, index: nil, value: targetValue})
 	}
 
+	walkStructs(rootPlan, targetValue, planPrefix{})
+
 	values.Merge(b.values)
+	valueOrigins.merge(b.origins)
+
+	var errs Errors
 
-	{
-		missingKeys := []string{}
+	if aliasErrs := b.applyAliases(values, valueOrigins, aliases); len(aliasErrs) > 0 {
+		if !b.aggregate {
+			return nil, aliasErrs[0]
+		}
+
+		errs = append(errs, aliasErrs...)
+	}
+
+	res := &buildResult{keys: make([]string, 0, len(knownFields))}
+	for key := range knownFields {
+		res.keys = append(res.keys, key)
+	}
+	sort.Strings(res.keys)
+
+	secretKeys = taintedKeys(values, secretKeys)
+	res.provenance = valueOrigins.provenance(values, secretKeys)
+
+	if b.strict {
+		known := make(map[string]bool, len(knownFields))
 		for key := range knownFields {
-			if _, ok := values.Lookup(key); !ok {
-				missingKeys = append(missingKeys, key)
+			known[key] = true
+		}
+
+		for _, v := range aliases {
+			for _, alias := range v {
+				known[alias] = true
 			}
 		}
-		sort.Strings(missingKeys)
 
-		if len(missingKeys) > 0 {
-			plural := ""
-			if len(missingKeys) > 1 {
-				plural = "s"
+		for key := range discriminators {
+			known[key] = true
+		}
+
+		if err := b.unknownKeysError(known); err != nil {
+			if !b.aggregate {
+				return res, err
 			}
 
-			return fmt.Errorf(
-				"missing %d configuration key%s: %s",
-				len(missingKeys), plural,
-				strings.Join(missingKeys, ", "))
+			for _, key := range err.Keys {
+				kerr := &UnknownKeysError{Keys: []string{key}, Suggestions: map[string]string{}}
+				if suggestion, ok := err.Suggestions[key]; ok {
+					kerr.Suggestions[key] = suggestion
+				}
+
+				errs = append(errs, kerr)
+			}
 		}
 	}
 
-	if err := resolveValueMap(values); err != nil {
-		return wrapError(err, "resolve values")
+	skip, disabled, conditionErrs := evaluateConditions(values, b.resolveOptions(), fieldIndices, requiredIf, toggles)
+	if len(conditionErrs) > 0 {
+		if !b.aggregate {
+			return res, conditionErrs[0]
+		}
+
+		errs = append(errs, conditionErrs...)
 	}
 
-	for key, field := range knownFields {
-		if err := values.Unmarshal(key, field.Addr().Interface()); err != nil {
-			return wrapError(err, "unmarshal value")
+	var missingKeys []string
+	for _, verr := range variantErrs {
+		if isWithin(verr.index, disabled) {
+			continue
+		}
+
+		switch err := verr.err.(type) {
+		case *MissingKeysError:
+			missingKeys = append(missingKeys, err.Keys...)
+			continue
+		case *UnmarshalError:
+			err.Source = res.provenance[err.Key].Source
+		}
+
+		if !b.aggregate {
+			return res, wrapError(verr.err, "unmarshal value")
 		}
+
+		errs = append(errs, verr.err)
 	}
 
-	if err := b.Validator().Struct(target); err != nil {
-		if errs, ok := err.(validator.ValidationErrors); ok {
-			keys := make([]string, 0, len(errs))
+	excluded := make([]string, len(disabled))
+	for i, index := range disabled {
+		excluded[i] = fieldNamespace(targetValue, index)
+		field := fieldByIndex(targetValue, index)
+		field.Set(reflect.Zero(field.Type()))
+	}
 
-			for _, err := range errs {
-				var key string
+	for key := range knownFields {
+		if skip[key] {
+			continue
+		}
 
-				if ns := strings.SplitN(err.StructNamespace(), ".", 2); len(ns) == 2 {
-					key = ns[1]
-				} else {
-					key = ns[0]
-				}
+		if _, ok := values.Lookup(key); !ok {
+			missingKeys = append(missingKeys, key)
+		}
+	}
+	sort.Strings(missingKeys)
+
+	if len(missingKeys) > 0 {
+		if !b.aggregate {
+			return res, &MissingKeysError{Keys: missingKeys}
+		}
 
-				key = stringReplaceAll(key, `.`, _separator)
-				key = normalizeKey(key)
-				keys = append(keys, key)
+		for _, key := range missingKeys {
+			errs = append(errs, &MissingKeysError{Keys: []string{key}})
+			skip[key] = true
+		}
+	}
+
+	used := make([]string, 0, len(res.keys))
+	for _, key := range res.keys {
+		if !skip[key] {
+			used = append(used, key)
+		}
+	}
+
+	options := b.resolveOptions()
+	if lookup := options.fallback; lookup != nil {
+		options.fallback = func(key string) (string, bool) {
+			value, ok := lookup(key)
+			if ok {
+				valueOrigins.add(key, value, Source{Kind: SourceEnvironFallback})
 			}
 
-			sort.Strings(keys)
+			return value, ok
+		}
+	}
 
-			return fmt.Errorf(`validation failed: %s`, strings.Join(keys, `, `))
+	if resolveErrs, failed := resolveKeys(values, used, options); len(resolveErrs) > 0 {
+		if !b.aggregate {
+			return res, wrapError(resolveErrs[0], "resolve values")
 		}
 
-		return err
+		errs = append(errs, resolveErrs...)
+		for key := range failed {
+			skip[key] = true
+		}
 	}
 
-	return nil
+	res.provenance = valueOrigins.provenance(values, secretKeys)
+
+	for _, key := range res.keys {
+		if skip[key] {
+			continue
+		}
+
+		if err := values.Unmarshal(key, knownFields[key].Addr().Interface()); err != nil {
+			uerr, ok := err.(*UnmarshalError)
+			if !ok {
+				uerr = &UnmarshalError{Key: key, Err: err}
+			}
+
+			uerr.Source = res.provenance[key].Source
+
+			if secretKeys[key] {
+				uerr.Err = fmt.Errorf(`invalid value %s`, _redacted)
+			}
+
+			if !b.aggregate {
+				return res, wrapError(uerr, "unmarshal value")
+			}
+
+			errs = append(errs, uerr)
+			skip[key] = true
+		}
+	}
+
+	sections = enabledSections(sections, disabled)
+
+	if len(errs) == 0 {
+		if err := finalizeSections(sections); err != nil {
+			if !b.aggregate {
+				return res, err
+			}
+
+			errs = append(errs, err)
+		}
+	}
+
+	validate := b.validator().Struct
+	if len(excluded) > 0 {
+		validate = func(s interface{}) error {
+			return b.validator().StructExcept(s, excluded...)
+		}
+	}
+
+	if err := validate(target); err != nil {
+		err = b.newValidationError(err, targetType.Name(), namespaces, secretKeys)
+		if !b.aggregate {
+			return res, err
+		}
+
+		if verr, ok := err.(*ValidationError); ok {
+			for _, f := range verr.Fields {
+				if !skip[f.Key] {
+					errs = append(errs, &ValidationError{Fields: []FieldError{f}})
+				}
+			}
+		} else {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) == 0 {
+		if hookErrs := validateSections(sections); len(hookErrs) > 0 {
+			if !b.aggregate {
+				return res, hookErrs[0]
+			}
+
+			errs = append(errs, hookErrs...)
+		}
+	}
+
+	if len(errs) > 0 {
+		errs.sort()
+		return res, errs
+	}
+
+	return res, nil
+}
+
+// WithAggregateErrors makes Build report every missing or unknown key,
+// conflicting alias, invalid condition, unresolvable reference, unmarshalling
+// failure and failed validation it finds, instead of stopping at the first.
+// The problems are returned as Errors, ordered by key.
+func (b *Builder) WithAggregateErrors(aggregate bool) *Builder {
+	if b.hasError() {
+		return b
+	}
+
+	b.aggregate = aggregate
+	return b
 }
 
 func (b *Builder) MustBuild(v interface{}) {
@@ -213,43 +497,75 @@ func (b *Builder) MergeFile(filename string) *Builder {
 		return b
 	}
 
-	return b.MergeData(data)
+	return b.mergeData(data, Source{Kind: SourceFile, Name: filename})
 }
 
+// MergeData merges the values set by lines of the form KEY=value, skipping
+// blank lines and comments. A value within a pair of single quotes, double
+// quotes or ANSI-C quotes, like $'a\nb', is unquoted as a shell would, so 'x'
+// and "x" both read as x, and "C:\new" as C:\new; see Map.WriteTo. Quotes
+// used to be kept as part of the value.
 func (b *Builder) MergeData(data []byte) *Builder {
+	return b.mergeData(data, Source{Kind: SourceData})
+}
+
+func (b *Builder) mergeData(data []byte, source Source) *Builder {
 	if b.hasError() {
 		return b
 	}
 
+	entries, err := parseEnvData(data, source)
+	if err != nil {
+		b.err = err
+		return b
+	}
+
+	return b.mergeEntries(entries)
+}
+
+// Parses lines of the form KEY=value, skipping empty lines and comments.
+// Values may be quoted as written by Map.WriteTo.
+func parseEnvData(data []byte, source Source) ([]entry, error) {
 	lines := bytes.Split(data, []byte("\n"))
-	m := make(Map, len(lines))
+	entries := make([]entry, 0, len(lines))
 
 	for i, line := range lines {
-		line := bytes.TrimSpace(line)
-
-		switch {
-		case len(line) == 0:
-			continue
-		case line[0] == '#':
+		key, value, ok := parseEnvLine(line)
+		if !ok {
 			continue
 		}
 
-		kvp := bytes.SplitN(line, []byte("="), 2)
-
-		key := string(bytes.TrimSpace(kvp[0]))
 		if len(key) == 0 {
-			b.err = fmt.Errorf(`invalid empty key on line %d`, i+1)
-			return b
+			return nil, fmt.Errorf(`invalid empty key on line %d`, i+1)
 		}
 
-		if len(kvp) == 1 {
-			m[key] = ``
-		} else {
-			m[key] = string(bytes.TrimSpace(kvp[1]))
-		}
+		e := entry{key: key, value: value, source: source}
+		e.source.Line = i + 1
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+// Returns false for empty lines and comments.
+func parseEnvLine(line []byte) (string, string, bool) {
+	line = bytes.TrimSpace(line)
+
+	switch {
+	case len(line) == 0:
+		return ``, ``, false
+	case line[0] == '#':
+		return ``, ``, false
+	}
+
+	kvp := bytes.SplitN(line, []byte("="), 2)
+
+	key := string(bytes.TrimSpace(kvp[0]))
+	if len(kvp) == 1 {
+		return key, ``, true
 	}
 
-	return b.MergeMap(m)
+	return key, unquoteValue(string(bytes.TrimSpace(kvp[1]))), true
 }
 
 func (b *Builder) MergeEnviron(prefix string, env []string) *Builder {
@@ -276,25 +592,72 @@ func (b *Builder) MergeEnviron(prefix string, env []string) *Builder {
 		}
 	}
 
-	return b.MergeMap(m)
+	return b.mergeMap(m, Source{Kind: SourceEnviron, Name: prefix})
 }
 
 func (b *Builder) MergeMap(m Map) *Builder {
+	return b.mergeMap(m, Source{Kind: SourceMap})
+}
+
+func (b *Builder) mergeMap(m Map, source Source) *Builder {
+	entries := make([]entry, 0, len(m))
+	for k, v := range m {
+		entries = append(entries, entry{key: k, value: v, source: source})
+	}
+
+	return b.mergeEntries(entries)
+}
+
+type entry struct {
+	key    string
+	value  string
+	source Source
+}
+
+func (b *Builder) mergeEntries(entries []entry) *Builder {
 	if b.hasError() {
 		return b
 	}
 
 	if b.values == nil {
 		b.values = Map{}
+		b.origins = origins{}
 	}
 
-	for k, v := range m {
-		b.values[k] = v
+	for _, e := range entries {
+		b.values[e.key] = e.value
+		b.origins.add(e.key, e.value, e.source)
 	}
 
 	return b
 }
 
+// Explain reports where the value of the given key came from during the most
+// recent call to Build or Dump to finish. It is safe to call concurrently with
+// them, but when building from several goroutines, which call is the most
+// recent is unspecified.
+func (b *Builder) Explain(key string) (Provenance, bool) {
+	p, ok := b.lastResult().provenance[normalizeKey(key)]
+	return p, ok
+}
+
+// Provenance returns the provenance of every key seen during the most recent
+// call to Build or Dump to finish, sorted by key.
+func (b *Builder) Provenance() []Provenance {
+	return sortedProvenance(b.lastResult().provenance)
+}
+
+func (b *Builder) lastResult() *buildResult {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.last == nil {
+		return &buildResult{}
+	}
+
+	return b.last
+}
+
 func (b *Builder) MapValidator(f func(v *validator.Validate)) *Builder {
 	if b.hasError() {
 		return b
@@ -311,3 +674,22 @@ func (b *Builder) Validator() *validator.Validate {
 
 	return b.validate
 }
+
+var (
+	_defaultValidator     *validator.Validate
+	_defaultValidatorOnce sync.Once
+)
+
+// Like Validator, but shares a single validator, and the struct metadata it
+// caches, between builders that haven't been given one.
+func (b *Builder) validator() *validator.Validate {
+	if b.validate != nil {
+		return b.validate
+	}
+
+	_defaultValidatorOnce.Do(func() {
+		_defaultValidator = validator.New()
+	})
+
+	return _defaultValidator
+}
@@ -1,8 +1,23 @@
 package readconf_test
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 
+	"github.com/go-playground/locales/en"
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+	entranslations "github.com/go-playground/validator/v10/translations/en"
 	"github.com/stretchr/testify/require"
 
 	"github.com/tetratom/readconf"
@@ -232,7 +247,9 @@ func TestBuilder_Build(t *testing.T) {
 		t.Run("failure", func(t *testing.T) {
 			var conf validationFailureConf
 			err := b().Build(&conf)
-			require.EqualError(t, err, "validation failed: BAR, FOO")
+			require.EqualError(t, err, "validation failed: "+
+				"BAR: must be at least 2 characters (got 1); "+
+				"FOO: must be at least 2 characters (got 1)")
 		})
 	})
 
@@ -327,6 +344,29 @@ func TestBuilder_MergeData(t *testing.T) {
 		},
 		ignore: "",
 	}, conf)
+
+	t.Run("quoted values", func(t *testing.T) {
+		var conf struct {
+			Path    string
+			Name    string
+			Spaced  string
+			Escaped string
+		}
+
+		err := b().
+			MergeData([]byte(`
+				PATH = "C:\new"
+				NAME = 'x'
+				SPACED = 'x y'
+				ESCAPED = $'x\ny'
+			`)).
+			Build(&conf)
+		require.NoError(t, err)
+		require.Equal(t, `C:\new`, conf.Path)
+		require.Equal(t, `x`, conf.Name)
+		require.Equal(t, `x y`, conf.Spaced)
+		require.Equal(t, "x\ny", conf.Escaped)
+	})
 }
 
 func TestBuilder_MergeEnviron(t *testing.T) {
@@ -367,3 +407,1536 @@ func TestBuilder_MultipleRefs(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, `bax_bax`, conf.Baf)
 }
+
+func TestBuilder_Provenance(t *testing.T) {
+	var conf struct {
+		Foo    string `default:"foo"`
+		Bar    string `default:"${FOO}_bar"`
+		Nested struct {
+			Bar int
+		}
+	}
+
+	builder := b().
+		MergeFile(`testdata/config.env`).
+		Set(`FOO`, `foo from set`)
+
+	err := builder.Build(&conf)
+	require.NoError(t, err)
+
+	foo, ok := builder.Explain(`foo`)
+	require.True(t, ok)
+	require.Equal(t, readconf.Provenance{
+		Key:    `FOO`,
+		Value:  `foo from set`,
+		Source: readconf.Source{Kind: readconf.SourceMap},
+		Shadowed: []readconf.Origin{
+			{
+				Value:  `foo from file`,
+				Source: readconf.Source{Kind: readconf.SourceFile, Name: `testdata/config.env`, Line: 1},
+			},
+			{
+				Value:  `foo`,
+				Source: readconf.Source{Kind: readconf.SourceDefaultTag, Name: `Foo`},
+			},
+		},
+	}, foo)
+
+	bar, ok := builder.Explain(`BAR`)
+	require.True(t, ok)
+	require.Equal(t, `foo from set_bar`, bar.Value)
+	require.Equal(t, `default tag Bar`, bar.Source.String())
+
+	nested, ok := builder.Explain(`NESTED__BAR`)
+	require.True(t, ok)
+	require.Equal(t, `file testdata/config.env:2`, nested.Source.String())
+	require.Empty(t, nested.Shadowed)
+
+	_, ok = builder.Explain(`MISSING`)
+	require.False(t, ok)
+
+	keys := []string{}
+	for _, p := range builder.Provenance() {
+		keys = append(keys, p.Key)
+	}
+	require.Equal(t, []string{`BAR`, `FOO`, `NESTED__BAR`}, keys)
+}
+
+type dumpConf struct {
+	User     string `default:"admin"`
+	Password string `default:"hunter2" secret:"true"`
+	DSN      string `default:"${USER}:${PASSWORD}@localhost"`
+	Nested   struct {
+		Bar int
+	}
+}
+
+func TestBuilder_Dump(t *testing.T) {
+	t.Run("table", func(t *testing.T) {
+		var conf dumpConf
+		var buf bytes.Buffer
+
+		err := b().
+			MergeFile(`testdata/config.env`).
+			Dump(&buf, &conf)
+		require.NoError(t, err)
+		require.Equal(t, `hunter2`, conf.Password)
+		require.Equal(t, ``+
+			"KEY          VALUE       SOURCE\n"+
+			"DSN          [REDACTED]  default tag DSN\n"+
+			"NESTED__BAR  1           file testdata/config.env:2\n"+
+			"PASSWORD     [REDACTED]  default tag Password\n"+
+			"USER         admin       default tag User\n",
+			buf.String())
+	})
+
+	t.Run("json", func(t *testing.T) {
+		var conf dumpConf
+		var buf bytes.Buffer
+
+		err := b().
+			Set(`NESTED__BAR`, `2`).
+			DumpJSON(&buf, &conf)
+		require.NoError(t, err)
+		require.JSONEq(t, `[
+			{"key": "DSN", "value": "[REDACTED]", "source": "default tag DSN"},
+			{"key": "NESTED__BAR", "value": "2", "source": "map"},
+			{"key": "PASSWORD", "value": "[REDACTED]", "source": "default tag Password"},
+			{"key": "USER", "value": "admin", "source": "default tag User"}
+		]`, buf.String())
+	})
+
+	t.Run("build failure", func(t *testing.T) {
+		var conf dumpConf
+		var buf bytes.Buffer
+
+		err := b().Dump(&buf, &conf)
+		require.EqualError(t, err, `missing 1 configuration key: NESTED__BAR`)
+		require.Empty(t, buf.String())
+	})
+}
+
+func TestSecret(t *testing.T) {
+	var conf struct {
+		Token  readconf.Secret `validate:"min=8"`
+		Key    readconf.SecretBytes
+		Tagged int `default:"1" secret:"true"`
+	}
+
+	t.Run("formatting", func(t *testing.T) {
+		err := b().Set(`TOKEN`, `hunter2hunter2`).Set(`KEY`, `s3cr3t`).Build(&conf)
+		require.NoError(t, err)
+		require.Equal(t, `hunter2hunter2`, conf.Token.Reveal())
+		require.Equal(t, []byte(`s3cr3t`), conf.Key.Reveal())
+
+		for _, s := range []string{
+			fmt.Sprint(conf.Token),
+			fmt.Sprintf(`%s %v %+v %x`, conf.Token, conf.Key, conf.Token, conf.Key),
+			fmt.Sprintf(`%#v`, conf),
+			fmt.Sprintf(`%q`, conf.Token),
+			conf.Token.String(),
+			conf.Key.GoString(),
+		} {
+			require.NotContains(t, s, `hunter2`)
+			require.NotContains(t, s, `s3cr3t`)
+			require.Contains(t, s, `[REDACTED]`)
+		}
+
+		data, err := json.Marshal(conf)
+		require.NoError(t, err)
+		require.JSONEq(t, `{"Token": "[REDACTED]", "Key": "[REDACTED]", "Tagged": 1}`, string(data))
+	})
+
+	t.Run("dump", func(t *testing.T) {
+		var buf bytes.Buffer
+		err := b().Set(`TOKEN`, `hunter2hunter2`).Set(`KEY`, `s3cr3t`).Dump(&buf, &conf)
+		require.NoError(t, err)
+		require.NotContains(t, buf.String(), `hunter2`)
+	})
+
+	t.Run("validation failure", func(t *testing.T) {
+		err := b().Set(`TOKEN`, `hunter2`).Set(`KEY`, `s3cr3t`).Build(&conf)
+		require.EqualError(t, err, `validation failed: TOKEN: must be at least 8 characters`)
+	})
+
+	t.Run("unmarshal failure", func(t *testing.T) {
+		err := b().Set(`TOKEN`, `hunter2hunter2`).Set(`KEY`, `s3cr3t`).Set(`TAGGED`, `hunter2`).Build(&conf)
+		require.EqualError(t, err, `unmarshal value: configuration key "TAGGED": invalid value [REDACTED]`)
+	})
+}
+
+func TestBuilder_WithStrict(t *testing.T) {
+	type config struct {
+		Foo    string `default:"foo"`
+		Nested struct {
+			Foo string `default:"foo"`
+			Bar int    `default:"1"`
+		}
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		var conf config
+		err := b().MergeData([]byte(`NESTD__FOO=bar`)).Build(&conf)
+		require.NoError(t, err)
+		require.Equal(t, `foo`, conf.Nested.Foo)
+	})
+
+	t.Run("file", func(t *testing.T) {
+		var conf config
+		err := b().
+			WithStrict(true).
+			MergeFile(`testdata/config.env`).
+			Build(&conf)
+		require.NoError(t, err)
+		require.Equal(t, `foo from file`, conf.Foo)
+	})
+
+	t.Run("data", func(t *testing.T) {
+		var conf config
+		err := b().
+			WithStrict(true).
+			MergeData([]byte("NESTD__FOO=bar\nCOMPLETELY_UNRELATED=1\nFOO=bar")).
+			Build(&conf)
+		require.EqualError(t, err, `unknown 2 configuration keys: `+
+			`COMPLETELY_UNRELATED, NESTD__FOO (did you mean NESTED__FOO?)`)
+	})
+
+	t.Run("environ", func(t *testing.T) {
+		var conf config
+		err := b().
+			WithStrict(true).
+			MergeEnviron(``, []string{`HOME=/root`}).
+			MergeEnviron(`APP__`, []string{`APP__NESTED__BAZ=1`, `OTHER__FOO=1`}).
+			Build(&conf)
+		require.EqualError(t, err, `unknown 1 configuration key: NESTED__BAZ (did you mean NESTED__BAR?)`)
+	})
+
+	t.Run("map", func(t *testing.T) {
+		var conf config
+		err := b().
+			WithStrict(true).
+			MergeMap(readconf.Map{`NESTD__FOO`: `bar`}).
+			Build(&conf)
+		require.NoError(t, err)
+	})
+}
+
+func TestBuilder_Aliases(t *testing.T) {
+	type config struct {
+		Foo    string `default:"foo" aliases:"OLD_FOO,OLDER_FOO"`
+		Nested struct {
+			Bar string `aliases:"OLD_BAR"`
+		}
+		Baz string `default:"baz"`
+	}
+
+	type warning struct {
+		key, message string
+	}
+
+	build := func(m readconf.Map) (config, []warning, error) {
+		var conf config
+		var warnings []warning
+
+		err := b().
+			WithAlias(`LEGACY_BAZ`, `BAZ`).
+			WithWarningFunc(func(key, message string) {
+				warnings = append(warnings, warning{key, message})
+			}).
+			MergeMap(m).
+			Build(&conf)
+
+		return conf, warnings, err
+	}
+
+	t.Run("new keys", func(t *testing.T) {
+		conf, warnings, err := build(readconf.Map{`NESTED__BAR`: `bar`})
+		require.NoError(t, err)
+		require.Empty(t, warnings)
+		require.Equal(t, `foo`, conf.Foo)
+		require.Equal(t, `bar`, conf.Nested.Bar)
+		require.Equal(t, `baz`, conf.Baz)
+	})
+
+	t.Run("old keys", func(t *testing.T) {
+		conf, warnings, err := build(readconf.Map{
+			`OLDER_FOO`:         `older_foo`,
+			`NESTED__OLD_BAR`:   `old_bar`,
+			`LEGACY_BAZ`:        `legacy_baz`,
+			`NESTED__OLDER_FOO`: `ignored`,
+		})
+		require.NoError(t, err)
+		require.Equal(t, `older_foo`, conf.Foo)
+		require.Equal(t, `old_bar`, conf.Nested.Bar)
+		require.Equal(t, `legacy_baz`, conf.Baz)
+		require.Equal(t, []warning{
+			{`LEGACY_BAZ`, `configuration key LEGACY_BAZ is deprecated, use BAZ instead`},
+			{`OLDER_FOO`, `configuration key OLDER_FOO is deprecated, use FOO instead`},
+			{`NESTED__OLD_BAR`, `configuration key NESTED__OLD_BAR is deprecated, use NESTED__BAR instead`},
+		}, warnings)
+	})
+
+	t.Run("same value", func(t *testing.T) {
+		conf, warnings, err := build(readconf.Map{
+			`FOO`:         `foo1`,
+			`OLD_FOO`:     `foo1`,
+			`NESTED__BAR`: `bar`,
+		})
+		require.NoError(t, err)
+		require.Equal(t, `foo1`, conf.Foo)
+		require.Len(t, warnings, 1)
+	})
+
+	t.Run("conflicting values", func(t *testing.T) {
+		_, _, err := build(readconf.Map{
+			`FOO`:         `foo1`,
+			`OLD_FOO`:     `foo2`,
+			`NESTED__BAR`: `bar`,
+		})
+		require.EqualError(t, err, `conflicting values for configuration keys FOO and OLD_FOO`)
+
+		_, _, err = build(readconf.Map{
+			`OLD_FOO`:     `foo1`,
+			`OLDER_FOO`:   `foo2`,
+			`NESTED__BAR`: `bar`,
+		})
+		require.EqualError(t, err, `conflicting values for configuration keys OLD_FOO and OLDER_FOO`)
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		var conf config
+		err := b().
+			WithStrict(true).
+			MergeData([]byte("NESTED__OLD_BAR=bar\nOLD_FOO=foo")).
+			Build(&conf)
+		require.NoError(t, err)
+		require.Equal(t, `bar`, conf.Nested.Bar)
+	})
+
+	t.Run("provenance", func(t *testing.T) {
+		var conf config
+		builder := b().MergeData([]byte("NESTED__OLD_BAR=bar"))
+		require.NoError(t, builder.Build(&conf))
+
+		p, ok := builder.Explain(`NESTED__BAR`)
+		require.True(t, ok)
+		require.Equal(t, readconf.Source{Kind: readconf.SourceData, Line: 1}, p.Source)
+	})
+}
+
+func TestBuilder_WithAggregateErrors(t *testing.T) {
+	var conf struct {
+		Alpha   int
+		Bravo   string `default:"${MISSING}"`
+		Charlie string `default:"${BRAVO}"`
+		Delta   string `validate:"min=2"`
+		Echo    string `default:"${ECHO}"`
+		Foxtrot int    `default:"1" validate:"min=2"`
+		Golf    string
+	}
+
+	err := b().
+		WithAggregateErrors(true).
+		Set(`ALPHA`, `not a number`).
+		Set(`DELTA`, `d`).
+		Build(&conf)
+
+	require.EqualError(t, err, "6 configuration errors:\n"+
+		"\tconfiguration key \"ALPHA\": strconv.ParseInt: parsing \"not a number\": invalid syntax\n"+
+		"\tkey MISSING referenced by BRAVO not found\n"+
+		"\tvalidation failed: DELTA: must be at least 2 characters (got 1)\n"+
+		"\tcyclic reference: ECHO, ECHO\n"+
+		"\tvalidation failed: FOXTROT: must be at least 2 (got 1)\n"+
+		"\tmissing 1 configuration key: GOLF")
+
+	errs, ok := err.(readconf.Errors)
+	require.True(t, ok)
+	require.IsType(t, &readconf.UnmarshalError{}, errs[0])
+	require.IsType(t, &readconf.ReferenceError{}, errs[1])
+	require.IsType(t, &readconf.ValidationError{}, errs[2])
+	require.IsType(t, &readconf.MissingKeysError{}, errs[5])
+	require.Equal(t, 1, conf.Foxtrot)
+
+	t.Run("strict", func(t *testing.T) {
+		var conf struct {
+			Port  int
+			Host  string `aliases:"HOSTNAME"`
+			Token string `required_if:"AUTH"`
+		}
+
+		err := b().
+			WithStrict(true).
+			WithAggregateErrors(true).
+			MergeData([]byte("PORTT=8080\nPORT=http")).
+			Set(`HOST`, `a`).
+			Set(`HOSTNAME`, `b`).
+			Set(`AUTH`, `maybe`).
+			Build(&conf)
+
+		require.EqualError(t, err, "4 configuration errors:\n"+
+			"\tconflicting values for configuration keys HOST and HOSTNAME\n"+
+			"\tconfiguration key \"PORT\": strconv.ParseInt: parsing \"http\": invalid syntax\n"+
+			"\tunknown 1 configuration key: PORTT (did you mean PORT?)\n"+
+			"\tinvalid required_if condition of TOKEN: "+
+			"configuration key \"AUTH\": strconv.ParseBool: parsing \"maybe\": invalid syntax")
+
+		errs, ok := err.(readconf.Errors)
+		require.True(t, ok)
+		require.Equal(t, &readconf.AliasConflictError{Key: `HOST`, Alias: `HOSTNAME`}, errs[0])
+		require.IsType(t, &readconf.UnmarshalError{}, errs[1])
+		require.Equal(t, &readconf.UnknownKeysError{
+			Keys:        []string{`PORTT`},
+			Suggestions: map[string]string{`PORTT`: `PORT`},
+		}, errs[2])
+		require.IsType(t, &readconf.ConditionError{}, errs[3])
+		require.Equal(t, `a`, conf.Host)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		var conf validationFailureConf
+		err := b().WithAggregateErrors(false).Build(&conf)
+		require.EqualError(t, err, "validation failed: "+
+			"BAR: must be at least 2 characters (got 1); "+
+			"FOO: must be at least 2 characters (got 1)")
+	})
+
+	t.Run("no errors", func(t *testing.T) {
+		var conf validationFailureConf
+		err := b().
+			WithAggregateErrors(true).
+			Set(`FOO`, `foo`).
+			Set(`BAR`, `bar`).
+			Build(&conf)
+		require.NoError(t, err)
+	})
+}
+
+type EmbeddedValidation struct {
+	EmbeddedURL string `default:"not a url" validate:"url"`
+}
+
+func TestBuilder_ValidationMessages(t *testing.T) {
+	var conf struct {
+		EmbeddedValidation
+		Level  string `default:"trace" validate:"oneof=debug info warn"`
+		Port   int    `default:"0" config:"LISTEN" validate:"required"`
+		Nested struct {
+			MaxConns int    `default:"200" validate:"lte=100"`
+			Password string `default:"x" validate:"min=8" secret:"true"`
+		}
+	}
+
+	err := b().Build(&conf)
+	require.EqualError(t, err, "validation failed: "+
+		`EMBEDDED_URL: must be a valid URL (got "not a url"); `+
+		`LEVEL: must be one of debug, info, warn (got "trace"); `+
+		`LISTEN: is required; `+
+		`NESTED__MAX_CONNS: must be at most 100 (got 200); `+
+		`NESTED__PASSWORD: must be at least 8 characters`)
+
+	t.Run("translator", func(t *testing.T) {
+		english := en.New()
+		trans, _ := ut.New(english, english).GetTranslator(`en`)
+
+		validate := validator.New()
+		require.NoError(t, entranslations.RegisterDefaultTranslations(validate, trans))
+
+		err := b().
+			WithValidator(validate).
+			WithTranslator(trans).
+			Build(&conf)
+		require.EqualError(t, err, "validation failed: "+
+			`EMBEDDED_URL: must be a valid URL; `+
+			`LEVEL: must be one of [debug info warn]; `+
+			`LISTEN: is a required field; `+
+			`NESTED__MAX_CONNS: must be 100 or less; `+
+			`NESTED__PASSWORD: must be at least 8 characters in length`)
+	})
+}
+
+type hooksTLS struct {
+	Enabled bool   `default:"false"`
+	Cert    string `default:""`
+}
+
+func (c *hooksTLS) Validate() error {
+	if c.Enabled && c.Cert == `` {
+		return errors.New(`CERT must be set when TLS is enabled`)
+	}
+
+	return nil
+}
+
+type hooksServer struct {
+	Host string `default:"localhost"`
+	Port int    `default:"80"`
+	TLS  hooksTLS
+	URL  string `config:"-"`
+}
+
+func (c *hooksServer) Finalize() error {
+	scheme := `http`
+	if c.TLS.Enabled {
+		scheme = `https`
+	}
+
+	c.URL = fmt.Sprintf(`%s://%s:%d`, scheme, c.Host, c.Port)
+	return nil
+}
+
+type hooksConf struct {
+	Server hooksServer
+	calls  []string
+}
+
+func (c *hooksConf) Finalize() error {
+	c.calls = append(c.calls, `finalize `+c.Server.URL)
+	return nil
+}
+
+func (c *hooksConf) Validate() error {
+	c.calls = append(c.calls, `validate`)
+	if c.Server.Port == 0 {
+		return errors.New(`port must not be zero`)
+	}
+
+	return nil
+}
+
+func TestBuilder_Hooks(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		var conf hooksConf
+		err := b().
+			Set(`SERVER__TLS__ENABLED`, `true`).
+			Set(`SERVER__TLS__CERT`, `cert.pem`).
+			Build(&conf)
+		require.NoError(t, err)
+		require.Equal(t, `https://localhost:80`, conf.Server.URL)
+		require.Equal(t, []string{`finalize https://localhost:80`, `validate`}, conf.calls)
+	})
+
+	t.Run("nested failure", func(t *testing.T) {
+		var conf hooksConf
+		err := b().Set(`SERVER__TLS__ENABLED`, `true`).Build(&conf)
+		require.EqualError(t, err, `SERVER__TLS: CERT must be set when TLS is enabled`)
+
+		herr, ok := err.(*readconf.HookError)
+		require.True(t, ok)
+		require.Equal(t, `SERVER__TLS`, herr.Key)
+		require.Equal(t, `Validate`, herr.Hook)
+	})
+
+	t.Run("aggregate", func(t *testing.T) {
+		var conf hooksConf
+		err := b().
+			WithAggregateErrors(true).
+			Set(`SERVER__TLS__ENABLED`, `true`).
+			Set(`SERVER__PORT`, `0`).
+			Build(&conf)
+		require.EqualError(t, err, "2 configuration errors:\n"+
+			"\tport must not be zero\n"+
+			"\tSERVER__TLS: CERT must be set when TLS is enabled")
+	})
+
+	t.Run("skipped after errors", func(t *testing.T) {
+		var conf hooksConf
+		err := b().Set(`SERVER__PORT`, `x`).Build(&conf)
+		require.Error(t, err)
+		require.Empty(t, conf.calls)
+	})
+}
+
+type conditionalCache struct {
+	Enabled bool
+	Host    string `validate:"hostname"`
+	TTL     int    `default:"60" validate:"min=1"`
+}
+
+func (c *conditionalCache) Validate() error {
+	return errors.New(`should not be called when disabled`)
+}
+
+type conditionalConf struct {
+	Mode  string           `default:"local"`
+	Cache conditionalCache `enabled_by:"CACHE__ENABLED"`
+	Queue struct {
+		URL string `required_if:"MODE=remote" validate:"required"`
+	}
+}
+
+func TestBuilder_Conditions(t *testing.T) {
+	t.Run("disabled", func(t *testing.T) {
+		conf := conditionalConf{Cache: conditionalCache{TTL: 5}}
+		err := b().Set(`CACHE__HOST`, `not a hostname!`).Build(&conf)
+		require.NoError(t, err)
+		require.Equal(t, conditionalCache{}, conf.Cache)
+		require.Equal(t, ``, conf.Queue.URL)
+
+		err = b().Set(`CACHE__ENABLED`, `false`).Build(&conf)
+		require.NoError(t, err)
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		var conf conditionalConf
+		err := b().
+			Set(`CACHE__ENABLED`, `${ON}`).
+			Set(`ON`, `true`).
+			Set(`MODE`, `remote`).
+			Build(&conf)
+		require.EqualError(t, err, `missing 2 configuration keys: CACHE__HOST, QUEUE__URL`)
+
+		err = b().
+			Set(`CACHE__ENABLED`, `true`).
+			Set(`CACHE__HOST`, `localhost`).
+			Set(`MODE`, `remote`).
+			Set(`QUEUE__URL`, `amqp://localhost`).
+			Build(&conf)
+		require.EqualError(t, err, `CACHE: should not be called when disabled`)
+		require.Equal(t, `localhost`, conf.Cache.Host)
+		require.Equal(t, 60, conf.Cache.TTL)
+		require.Equal(t, `amqp://localhost`, conf.Queue.URL)
+	})
+
+	t.Run("optional value set", func(t *testing.T) {
+		var conf conditionalConf
+		err := b().Set(`QUEUE__URL`, `amqp://localhost`).Build(&conf)
+		require.NoError(t, err)
+		require.Equal(t, `amqp://localhost`, conf.Queue.URL)
+	})
+
+	t.Run("invalid switch", func(t *testing.T) {
+		var conf conditionalConf
+		err := b().Set(`CACHE__ENABLED`, `yes`).Build(&conf)
+		require.EqualError(t, err, `invalid enabled_by condition of CACHE: `+
+			`configuration key "CACHE__ENABLED": strconv.ParseBool: parsing "yes": invalid syntax`)
+	})
+}
+
+type storage interface {
+	URL() string
+}
+
+type s3Storage struct {
+	Bucket string `validate:"min=3"`
+	Region string `default:"us-east-1"`
+}
+
+func (s *s3Storage) URL() string {
+	return fmt.Sprintf(`s3://%s?region=%s`, s.Bucket, s.Region)
+}
+
+type gcsStorage struct {
+	Bucket  string
+	Project string
+}
+
+func (s *gcsStorage) URL() string {
+	return fmt.Sprintf(`gs://%s/%s`, s.Project, s.Bucket)
+}
+
+func (gcsStorage) DefaultConfig() readconf.Map {
+	return readconf.Map{`PROJECT`: `default-project`}
+}
+
+type variantDefaults struct {
+	Storage storage `default:"s3"`
+}
+
+func (variantDefaults) DefaultConfig() readconf.Map {
+	return readconf.Map{`STORAGE__TYPE`: `gcs`}
+}
+
+func TestBuilder_RegisterVariant(t *testing.T) {
+	type config struct {
+		Storage storage `default:"s3"`
+		Backup  storage `discriminator:"KIND"`
+	}
+
+	builder := func() *readconf.Builder {
+		return b().
+			RegisterVariant((*storage)(nil), `s3`, s3Storage{}).
+			RegisterVariant((*storage)(nil), `gcs`, &gcsStorage{})
+	}
+
+	t.Run("selected by discriminator", func(t *testing.T) {
+		var conf config
+		err := builder().
+			MergeData([]byte(`
+				STORAGE__BUCKET = main
+				BACKUP__KIND = gcs
+				BACKUP__BUCKET = backup
+			`)).
+			Build(&conf)
+		require.NoError(t, err)
+		require.Equal(t, `s3://main?region=us-east-1`, conf.Storage.URL())
+		require.Equal(t, `gs://default-project/backup`, conf.Backup.URL())
+	})
+
+	t.Run("missing keys of variant", func(t *testing.T) {
+		var conf config
+		err := builder().
+			Set(`STORAGE__TYPE`, `gcs`).
+			Set(`BACKUP__KIND`, `s3`).
+			Build(&conf)
+		require.EqualError(t, err, `missing 2 configuration keys: BACKUP__BUCKET, STORAGE__BUCKET`)
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		var conf config
+		err := builder().
+			Set(`STORAGE__BUCKET`, `ab`).
+			Set(`BACKUP__KIND`, `gcs`).
+			Set(`BACKUP__BUCKET`, `backup`).
+			Build(&conf)
+		require.EqualError(t, err, `validation failed: STORAGE__BUCKET: must be at least 3 characters (got 2)`)
+	})
+
+	t.Run("missing discriminator", func(t *testing.T) {
+		var conf config
+		err := builder().Set(`STORAGE__BUCKET`, `main`).Build(&conf)
+		require.EqualError(t, err, `missing 1 configuration key: BACKUP__KIND`)
+	})
+
+	t.Run("unknown discriminator", func(t *testing.T) {
+		var conf config
+		err := builder().Set(`STORAGE__TYPE`, `ftp`).Build(&conf)
+		require.EqualError(t, err, `unmarshal value: configuration key "STORAGE__TYPE": `+
+			`unknown variant "ftp", expected one of: gcs, s3`)
+	})
+
+	t.Run("aggregate", func(t *testing.T) {
+		var conf config
+		err := builder().
+			WithAggregateErrors(true).
+			Set(`STORAGE__TYPE`, `ftp`).
+			Build(&conf)
+		require.EqualError(t, err, "2 configuration errors:\n"+
+			"\tmissing 1 configuration key: BACKUP__KIND\n"+
+			"\tconfiguration key \"STORAGE__TYPE\": unknown variant \"ftp\", expected one of: gcs, s3")
+
+		errs, ok := err.(readconf.Errors)
+		require.True(t, ok)
+		require.IsType(t, &readconf.MissingKeysError{}, errs[0])
+		require.Equal(t, readconf.Source{Kind: readconf.SourceMap}, errs[1].(*readconf.UnmarshalError).Source)
+	})
+
+	t.Run("disabled section", func(t *testing.T) {
+		var conf struct {
+			Backup struct {
+				Enabled bool `default:"false"`
+				Store   storage
+			} `enabled_by:"BACKUP__ENABLED"`
+		}
+
+		err := builder().Build(&conf)
+		require.NoError(t, err)
+		require.Nil(t, conf.Backup.Store)
+
+		err = builder().Set(`BACKUP__ENABLED`, `true`).Build(&conf)
+		require.EqualError(t, err, `missing 1 configuration key: BACKUP__STORE__TYPE`)
+	})
+
+	t.Run("discriminator from DefaultConfig", func(t *testing.T) {
+		var conf variantDefaults
+		err := builder().Set(`STORAGE__BUCKET`, `main`).Build(&conf)
+		require.NoError(t, err)
+		require.Equal(t, `gs://default-project/main`, conf.Storage.URL())
+	})
+
+	t.Run("discriminator from alias", func(t *testing.T) {
+		var conf variantDefaults
+		err := builder().
+			WithAlias(`STORAGE__KIND`, `STORAGE__TYPE`).
+			Set(`STORAGE__KIND`, `s3`).
+			Set(`STORAGE__BUCKET`, `main`).
+			Build(&conf)
+		require.NoError(t, err)
+		require.Equal(t, `s3://main?region=us-east-1`, conf.Storage.URL())
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		var conf config
+		err := builder().
+			WithStrict(true).
+			MergeData([]byte("STORAGE__TYPE=gcs\nSTORAGE__BUCKET=main\nBACKUP__KIND=s3\nBACKUP__BUCKET=backup\nBACKUP__PROJECT=x")).
+			Build(&conf)
+		require.EqualError(t, err, `unknown 1 configuration key: BACKUP__PROJECT (did you mean BACKUP__BUCKET?)`)
+	})
+
+	t.Run("invalid registration", func(t *testing.T) {
+		err := b().RegisterVariant(storage(nil), `s3`, s3Storage{}).Error()
+		require.EqualError(t, err, `expected a pointer to an interface`)
+
+		err = b().RegisterVariant((*storage)(nil), `s3`, `s3`).Error()
+		require.EqualError(t, err, `expected variant to be a struct`)
+
+		err = b().RegisterVariant((*storage)(nil), `s3`, struct{}{}).Error()
+		require.EqualError(t, err, `variant *struct {} does not implement readconf_test.storage`)
+	})
+}
+
+func TestBuilder_RegisterResolver(t *testing.T) {
+	var conf struct {
+		Home   string `default:"${env:READCONF_TEST_HOME:-/root}"`
+		Token  string `default:"${file:testdata/token}"`
+		Vault  string `default:"${vault:secret/db#${FIELD}}"`
+		Field  string `default:"password"`
+		Absent string `default:"${file:testdata/absent:-none}"`
+	}
+
+	vault := func(arg string) (string, error) {
+		return `vault(` + arg + `)`, nil
+	}
+
+	err := b().RegisterResolver(`vault`, vault).Build(&conf)
+	require.NoError(t, err)
+	require.Equal(t, `/root`, conf.Home)
+	require.Equal(t, `s3cr3t`, conf.Token)
+	require.Equal(t, `vault(secret/db#password)`, conf.Vault)
+	require.Equal(t, `none`, conf.Absent)
+
+	require.NoError(t, os.Setenv(`READCONF_TEST_HOME`, `/home/test`))
+	defer os.Unsetenv(`READCONF_TEST_HOME`)
+
+	err = b().RegisterResolver(`vault`, vault).Build(&conf)
+	require.NoError(t, err)
+	require.Equal(t, `/home/test`, conf.Home)
+
+	err = b().Build(&conf)
+	require.EqualError(t, err, `resolve values: key vault:secret/db#password referenced by VAULT: unknown namespace vault`)
+}
+
+func TestBuilder_RegisterFunc(t *testing.T) {
+	var conf struct {
+		Host string `default:"${HOSTNAME|trim|lower}"`
+		URL  string `default:"https://${HOST}/${PATH|slug}"`
+	}
+
+	slug := func(value, _ string) (string, error) {
+		return strings.Replace(strings.ToLower(value), ` `, `-`, -1), nil
+	}
+
+	err := b().
+		Set(`HOSTNAME`, ` Example.COM `).
+		Set(`PATH`, `Hello World`).
+		RegisterFunc(`slug`, slug).
+		Build(&conf)
+	require.NoError(t, err)
+	require.Equal(t, `example.com`, conf.Host)
+	require.Equal(t, `https://example.com/hello-world`, conf.URL)
+
+	err = b().Set(`HOSTNAME`, `x`).Set(`PATH`, `y`).Build(&conf)
+	require.EqualError(t, err, `resolve values: key PATH referenced by URL: unknown function slug`)
+}
+
+func TestBuilder_ResolveUsedKeys(t *testing.T) {
+	var conf struct {
+		Name string
+		URL  string `default:"https://${HOST}/${NAME}"`
+	}
+
+	err := b().
+		MergeMap(map[string]string{
+			`NAME`:      `app`,
+			`HOST`:      `example.com`,
+			`MALFORMED`: `${oops`,
+			`CYCLE_A`:   `$CYCLE_B`,
+			`CYCLE_B`:   `$CYCLE_A`,
+		}).
+		Build(&conf)
+	require.NoError(t, err)
+	require.Equal(t, `https://example.com/app`, conf.URL)
+
+	err = b().Set(`NAME`, `${oops`).Set(`HOST`, `x`).Build(&conf)
+	require.EqualError(t, err, `resolve values: invalid reference in NAME at offset 0: unterminated reference`)
+}
+
+func TestBuilder_WithEnvironFallback(t *testing.T) {
+	var conf struct {
+		LogDir string `default:"${HOME}/logs"`
+		Owner  string `default:"${OWNER_NAME:-nobody}"`
+	}
+
+	err := b().Build(&conf)
+	require.EqualError(t, err, `resolve values: key HOME referenced by LOG_DIR not found`)
+
+	lookup := func(key string) (string, bool) {
+		if key == `HOME` {
+			return `/home/${x}`, true
+		}
+
+		return ``, false
+	}
+
+	builder := b().WithEnvironFallback(lookup)
+	err = builder.Build(&conf)
+	require.NoError(t, err)
+	require.Equal(t, `/home/${x}/logs`, conf.LogDir)
+	require.Equal(t, `nobody`, conf.Owner)
+
+	p, ok := builder.Explain(`HOME`)
+	require.True(t, ok)
+	require.Equal(t, `/home/${x}`, p.Value)
+	require.Equal(t, `environment fallback`, p.Source.String())
+
+	require.NoError(t, os.Setenv(`READCONF_TEST_DIR`, `/var`))
+	defer os.Unsetenv(`READCONF_TEST_DIR`)
+
+	err = b().WithEnvironFallback(nil).Set(`LOG_DIR`, `${READCONF_TEST_DIR}/log`).Build(&conf)
+	require.NoError(t, err)
+	require.Equal(t, `/var/log`, conf.LogDir)
+}
+
+func TestBuilder_BuildConcurrently(t *testing.T) {
+	type config struct {
+		Name   string `validate:"required"`
+		Nested struct {
+			Port int `default:"80"`
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 16)
+
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			var conf config
+			errs[i] = b().Set(`NAME`, fmt.Sprint(i)).Build(&conf)
+			if errs[i] == nil && (conf.Name != fmt.Sprint(i) || conf.Nested.Port != 80) {
+				errs[i] = fmt.Errorf(`unexpected config %+v`, conf)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+}
+
+func TestBuilder_BuildSharedBuilder(t *testing.T) {
+	type config struct {
+		Name   string `validate:"required"`
+		Token  readconf.Secret
+		Nested struct {
+			Port int `default:"80"`
+		}
+	}
+
+	builder := b().Set(`NAME`, `app`).Set(`TOKEN`, `s3cr3t`)
+
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			var conf config
+			var buf bytes.Buffer
+
+			if i%2 == 0 {
+				errs[i] = builder.Build(&conf)
+			} else {
+				errs[i] = builder.Dump(&buf, &conf)
+			}
+
+			if _, ok := builder.Explain(`NAME`); !ok && errs[i] == nil {
+				errs[i] = fmt.Errorf(`NAME not explained`)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+
+	require.Len(t, builder.Provenance(), 3)
+}
+
+func BenchmarkBuilder_Build(b *testing.B) {
+	type section struct {
+		Host     string `default:"localhost"`
+		Port     int    `default:"8080" validate:"min=1"`
+		User     string `default:"user"`
+		Password string `default:"secret" secret:"true"`
+		Timeout  string `default:"10s"`
+		Retries  int    `default:"3"`
+		Enabled  bool   `default:"true"`
+		Name     string `default:"${DATABASE__HOST}:${DATABASE__PORT}"`
+	}
+
+	type config struct {
+		Database, Cache, Queue, Search, Storage section
+		Metrics, Tracing, Logging, Mail, Auth   struct {
+			Primary, Secondary section
+		}
+	}
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		var conf config
+		if err := readconf.NewBuilder().Set(`DATABASE__HOST`, `db`).Build(&conf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	fields, err := readconf.Describe(configWithInterfacedDefaults{})
+	require.NoError(t, err)
+	require.Equal(t, []readconf.FieldInfo{
+		{Key: `FOO`, Path: `Foo`, Type: `string`, Default: `test1`, HasDefault: true},
+		{Key: `BAR`, Path: `Bar`, Type: `int`, Default: `2`, HasDefault: true},
+		{
+			Key:        `EMBEDDED_FOO`,
+			Path:       `EmbeddedWithInterfacedDefaults.EmbeddedFoo`,
+			Type:       `string`,
+			Default:    `test11`,
+			HasDefault: true,
+		},
+		{
+			Key:        `EMBEDDED_BAR`,
+			Path:       `EmbeddedWithInterfacedDefaults.EmbeddedBar`,
+			Type:       `int`,
+			Default:    `12`,
+			HasDefault: true,
+		},
+		{Key: `NESTED__FOO`, Path: `Nested.Foo`, Type: `string`, Default: `test21`, HasDefault: true},
+		{Key: `NESTED__BAR`, Path: `Nested.Bar`, Type: `int`, Default: `22`, HasDefault: true},
+	}, fields)
+
+	var conf struct {
+		Mode   string          `validate:"required,oneof=dev prod" description:"Deployment mode"`
+		Token  readconf.Secret `required_if:"MODE=prod"`
+		Backup struct {
+			Bucket string
+		} `enabled_by:"BACKUP_ENABLED"`
+		Storage interface{}
+	}
+
+	conf.Storage = &struct{ Path string }{}
+
+	fields, err = readconf.Describe(&conf)
+	require.NoError(t, err)
+	require.Equal(t, []readconf.FieldInfo{
+		{
+			Key:         `MODE`,
+			Path:        `Mode`,
+			Type:        `string`,
+			Required:    true,
+			Validate:    `required,oneof=dev prod`,
+			Description: `Deployment mode`,
+			Enum:        []string{`dev`, `prod`},
+		},
+		{Key: `TOKEN`, Path: `Token`, Type: `readconf.Secret`, RequiredIf: `MODE=prod`, Secret: true},
+		{Key: `BACKUP__BUCKET`, Path: `Backup.Bucket`, Type: `string`},
+		{Key: `STORAGE__PATH`, Path: `Storage.Path`, Type: `string`, Required: true},
+	}, fields)
+
+	_, err = readconf.Describe(1)
+	require.EqualError(t, err, `expected struct or pointer to struct`)
+
+	t.Run("variants", func(t *testing.T) {
+		var conf struct {
+			Storage storage `default:"s3" description:"Where to store files"`
+			Backup  storage `discriminator:"KIND"`
+		}
+
+		conf.Storage = &gcsStorage{}
+
+		fields, err := b().
+			RegisterVariant((*storage)(nil), `s3`, s3Storage{}).
+			RegisterVariant((*storage)(nil), `gcs`, &gcsStorage{}).
+			Describe(&conf)
+		require.NoError(t, err)
+		require.Equal(t, []readconf.FieldInfo{
+			{
+				Key:         `STORAGE__TYPE`,
+				Path:        `Storage`,
+				Type:        `string`,
+				Default:     `s3`,
+				HasDefault:  true,
+				Description: `Where to store files`,
+				Enum:        []string{`gcs`, `s3`},
+			},
+			{Key: `STORAGE__BUCKET`, Path: `Storage.Bucket`, Type: `string`, Required: true},
+			{
+				Key:        `STORAGE__PROJECT`,
+				Path:       `Storage.Project`,
+				Type:       `string`,
+				Default:    `default-project`,
+				HasDefault: true,
+			},
+			{Key: `BACKUP__KIND`, Path: `Backup`, Type: `string`, Required: true, Enum: []string{`gcs`, `s3`}},
+		}, fields)
+	})
+}
+
+func TestWriteEnvExample(t *testing.T) {
+	var conf struct {
+		Mode     string          `default:"dev" validate:"oneof=dev prod" description:"Deployment mode"`
+		Token    readconf.Secret `default:"changeme" required_if:"MODE=prod"`
+		Name     string
+		Database struct {
+			Host string `default:"localhost"`
+			Port int    `default:"5432" validate:"min=1"`
+			User string `description:"Login user"`
+		}
+		Debug bool `default:"false"`
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, readconf.WriteEnvExample(&buf, &conf))
+	require.Equal(t, `# Deployment mode
+# validate: oneof=dev prod
+MODE=dev
+
+# required if MODE=prod; secret
+TOKEN=
+
+# required
+NAME=
+DEBUG=false
+
+# DATABASE
+
+DATABASE__HOST=localhost
+
+# validate: min=1
+DATABASE__PORT=5432
+
+# Login user
+# required
+DATABASE__USER=
+`, buf.String())
+
+	err := b().MergeData(buf.Bytes()).Build(&conf)
+	require.NoError(t, err)
+	require.Equal(t, `dev`, conf.Mode)
+	require.Equal(t, 5432, conf.Database.Port)
+
+	t.Run("top-level field after nested struct", func(t *testing.T) {
+		var conf struct {
+			A      string
+			Nested struct {
+				X string
+				Y struct {
+					Z string
+				}
+			}
+			B string
+		}
+
+		var buf bytes.Buffer
+		require.NoError(t, readconf.WriteEnvExample(&buf, &conf))
+		require.Equal(t, `# required
+A=
+
+# required
+B=
+
+# NESTED
+
+# required
+NESTED__X=
+
+# NESTED__Y
+
+# required
+NESTED__Y__Z=
+`, buf.String())
+	})
+
+	t.Run("quoted defaults", func(t *testing.T) {
+		var conf struct {
+			Quoted string `default:"'x'"`
+			Lead   string `default:" lead"`
+			Path   string `default:"C:\\new"`
+		}
+
+		var buf bytes.Buffer
+		require.NoError(t, readconf.WriteEnvExample(&buf, &conf))
+		require.Equal(t, `QUOTED="'x'"
+LEAD=' lead'
+PATH='C:\new'
+`, buf.String())
+
+		var conf2 struct {
+			Quoted string
+			Lead   string
+			Path   string
+		}
+
+		err := b().MergeData(buf.Bytes()).Build(&conf2)
+		require.NoError(t, err)
+		require.Equal(t, `'x'`, conf2.Quoted)
+		require.Equal(t, ` lead`, conf2.Lead)
+		require.Equal(t, `C:\new`, conf2.Path)
+	})
+}
+
+func TestWriteMarkdown(t *testing.T) {
+	var conf struct {
+		Mode     string          `default:"dev" validate:"oneof=dev prod" description:"Deployment mode"`
+		Token    readconf.Secret `default:"changeme" required_if:"MODE=prod"`
+		Database struct {
+			Port int    `default:"5432"`
+			Host string `description:"Host name | address"`
+		}
+		Debug bool `default:"false"`
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, readconf.WriteMarkdown(&buf, &conf))
+	require.Equal(t, "| Key | Type | Default | Required | Allowed values | Description |\n"+
+		"| --- | --- | --- | --- | --- | --- |\n"+
+		"| `DEBUG` | `bool` | `false` | no |  |  |\n"+
+		"| `MODE` | `string` | `dev` | no | `dev, prod` | Deployment mode |\n"+
+		"| `TOKEN` | `readconf.Secret` | `[REDACTED]` | if MODE=prod |  |  |\n"+
+		"\n"+
+		"## DATABASE\n"+
+		"\n"+
+		"| Key | Type | Default | Required | Allowed values | Description |\n"+
+		"| --- | --- | --- | --- | --- | --- |\n"+
+		"| `DATABASE__HOST` | `string` |  | yes |  | Host name \\| address |\n"+
+		"| `DATABASE__PORT` | `int` | `5432` | no |  |  |\n",
+		buf.String())
+
+	buf.Reset()
+	require.NoError(t, readconf.WriteHTML(&buf, &conf))
+	require.Equal(t, `<table>
+<thead>
+<tr><th>Key</th><th>Type</th><th>Default</th><th>Required</th><th>Allowed values</th><th>Description</th></tr>
+</thead>
+<tbody>
+<tr><td><code>DEBUG</code></td><td><code>bool</code></td><td><code>false</code></td><td>no</td><td></td><td></td></tr>
+<tr><td><code>MODE</code></td><td><code>string</code></td><td><code>dev</code></td><td>no</td><td><code>dev, prod</code></td><td>Deployment mode</td></tr>
+<tr><td><code>TOKEN</code></td><td><code>readconf.Secret</code></td><td><code>[REDACTED]</code></td><td>if MODE=prod</td><td></td><td></td></tr>
+</tbody>
+</table>
+<h2>DATABASE</h2>
+<table>
+<thead>
+<tr><th>Key</th><th>Type</th><th>Default</th><th>Required</th><th>Allowed values</th><th>Description</th></tr>
+</thead>
+<tbody>
+<tr><td><code>DATABASE__HOST</code></td><td><code>string</code></td><td></td><td>yes</td><td></td><td>Host name | address</td></tr>
+<tr><td><code>DATABASE__PORT</code></td><td><code>int</code></td><td><code>5432</code></td><td>no</td><td></td><td></td></tr>
+</tbody>
+</table>
+`, buf.String())
+}
+
+func TestJSONSchema(t *testing.T) {
+	var conf struct {
+		Mode     string          `default:"dev" validate:"oneof=dev prod" description:"Deployment mode"`
+		Token    readconf.Secret `default:"changeme" validate:"min=8"`
+		Endpoint string          `validate:"required,url"`
+		Database struct {
+			Port int  `default:"5432" validate:"min=1,lt=65536"`
+			TLS  bool `config:"tls" default:"true"`
+		}
+	}
+
+	data, err := readconf.JSONSchema(&conf)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"type": "object",
+		"properties": {
+			"MODE": {"type": "string", "default": "dev", "enum": ["dev", "prod"], "description": "Deployment mode"},
+			"TOKEN": {"type": "string", "writeOnly": true, "minLength": 8},
+			"ENDPOINT": {"type": "string", "minLength": 1, "format": "uri"},
+			"DATABASE__PORT": {"type": "integer", "default": 5432, "minimum": 1, "exclusiveMaximum": 65536},
+			"DATABASE__TLS": {"type": "boolean", "default": true}
+		},
+		"required": ["ENDPOINT"]
+	}`, string(data))
+
+	data, err = readconf.NestedJSONSchema(&conf)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"type": "object",
+		"properties": {
+			"MODE": {"type": "string", "default": "dev", "enum": ["dev", "prod"], "description": "Deployment mode"},
+			"TOKEN": {"type": "string", "writeOnly": true, "minLength": 8},
+			"ENDPOINT": {"type": "string", "minLength": 1, "format": "uri"},
+			"DATABASE": {
+				"type": "object",
+				"properties": {
+					"PORT": {"type": "integer", "default": 5432, "minimum": 1, "exclusiveMaximum": 65536},
+					"TLS": {"type": "boolean", "default": true}
+				}
+			}
+		},
+		"required": ["ENDPOINT"]
+	}`, string(data))
+}
+
+type hostPort struct {
+	Host string
+	Port int
+}
+
+func (h hostPort) MarshalConfig() (string, error) {
+	return fmt.Sprintf(`%s:%d`, h.Host, h.Port), nil
+}
+
+func (h *hostPort) UnmarshalConfig(s string) error {
+	_, err := fmt.Sscanf(strings.Replace(s, `:`, ` `, 1), `%s %d`, &h.Host, &h.Port)
+	return err
+}
+
+func TestMarshal(t *testing.T) {
+	type config struct {
+		Name     string
+		Price    string `config:"cost"`
+		Retries  int
+		Debug    bool
+		Token    readconf.Secret
+		Server   hostPort
+		Database struct {
+			Host string
+		}
+		Skipped string `config:"-"`
+	}
+
+	conf := config{
+		Name:    `app`,
+		Price:   `$5 or ${X}`,
+		Retries: 3,
+		Debug:   true,
+		Token:   `s3cr3t`,
+		Server:  hostPort{Host: `example.com`, Port: 8080},
+	}
+	conf.Database.Host = `db`
+
+	m, err := readconf.Marshal(&conf)
+	require.NoError(t, err)
+	require.Equal(t, readconf.Map{
+		`NAME`:           `app`,
+		`COST`:           `$$5 or $${X}`,
+		`RETRIES`:        `3`,
+		`DEBUG`:          `true`,
+		`TOKEN`:          `s3cr3t`,
+		`SERVER`:         `example.com:8080`,
+		`SERVER__HOST`:   `example.com`,
+		`SERVER__PORT`:   `8080`,
+		`DATABASE__HOST`: `db`,
+	}, m)
+
+	var conf2 config
+	require.NoError(t, b().MergeMap(m).Build(&conf2))
+	require.Equal(t, conf, conf2)
+
+	_, err = readconf.Marshal(struct{ Ratio complex64 }{})
+	require.EqualError(t, err, `marshal RATIO: cannot marshal value of type complex64`)
+
+	t.Run("kinds", func(t *testing.T) {
+		type config struct {
+			String  string
+			Int     int
+			Int8    int8
+			Int16   int16
+			Int32   int32
+			Int64   int64
+			Uint    uint
+			Uint8   uint8
+			Uint16  uint16
+			Uint32  uint32
+			Uint64  uint64
+			Float32 float32
+			Float64 float64
+			Bool    bool
+			Secret  readconf.Secret
+			IP      net.IP
+		}
+
+		conf := config{
+			String:  `s`,
+			Int:     -1,
+			Int8:    math.MinInt8,
+			Int16:   math.MinInt16,
+			Int32:   math.MinInt32,
+			Int64:   math.MinInt64,
+			Uint:    1,
+			Uint8:   math.MaxUint8,
+			Uint16:  math.MaxUint16,
+			Uint32:  math.MaxUint32,
+			Uint64:  math.MaxUint64,
+			Float32: 0.1,
+			Float64: 0.5,
+			Bool:    true,
+			Secret:  `s3cr3t`,
+			IP:      net.IPv4(127, 0, 0, 1),
+		}
+
+		m, err := readconf.Marshal(&conf)
+		require.NoError(t, err)
+
+		var conf2 config
+		require.NoError(t, b().MergeMap(m).Build(&conf2))
+		require.Equal(t, conf, conf2)
+	})
+
+	t.Run("variants", func(t *testing.T) {
+		type config struct {
+			Storage storage
+			Backup  storage `discriminator:"KIND"`
+		}
+
+		builder := b().
+			RegisterVariant((*storage)(nil), `s3`, s3Storage{}).
+			RegisterVariant((*storage)(nil), `gcs`, &gcsStorage{})
+
+		conf := config{
+			Storage: &s3Storage{Bucket: `main`, Region: `eu-west-1`},
+			Backup:  &gcsStorage{Bucket: `backup`, Project: `archive`},
+		}
+
+		m, err := builder.Marshal(&conf)
+		require.NoError(t, err)
+		require.Equal(t, readconf.Map{
+			`STORAGE__TYPE`:   `s3`,
+			`STORAGE__BUCKET`: `main`,
+			`STORAGE__REGION`: `eu-west-1`,
+			`BACKUP__KIND`:    `gcs`,
+			`BACKUP__BUCKET`:  `backup`,
+			`BACKUP__PROJECT`: `archive`,
+		}, m)
+
+		var buf bytes.Buffer
+		_, err = m.WriteTo(&buf)
+		require.NoError(t, err)
+
+		var conf2 config
+		require.NoError(t, builder.MergeData(buf.Bytes()).Build(&conf2))
+		require.Equal(t, conf, conf2)
+
+		conf.Backup = &struct{ storage }{}
+		_, err = builder.Marshal(&conf)
+		require.EqualError(t, err, `marshal BACKUP: struct { readconf_test.storage } `+
+			`is not a registered variant of readconf_test.storage`)
+	})
+}
+
+func TestMap_WriteEnvFile(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `readconf`)
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	type config struct {
+		Name  string
+		Price string
+		Notes string
+		Key   readconf.SecretBytes
+	}
+
+	conf := config{Name: `app`, Price: `$5`, Notes: "it's\n\"multi\" # line", Key: []byte("\xff\x00\x01")}
+
+	m, err := readconf.Marshal(&conf)
+	require.NoError(t, err)
+
+	filename := filepath.Join(dir, `app.env`)
+	require.NoError(t, m.WriteEnvFile(filename))
+
+	fi, err := os.Stat(filename)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	var conf2 config
+	require.NoError(t, b().MergeFile(filename).Build(&conf2))
+	require.Equal(t, conf, conf2)
+
+	require.NoError(t, os.Chmod(filename, 0644))
+	require.NoError(t, readconf.Map{`NAME`: `other`}.WriteEnvFile(filename))
+
+	data, err := ioutil.ReadFile(filename)
+	require.NoError(t, err)
+	require.Equal(t, "NAME=other\n", string(data))
+
+	fi, err = os.Stat(filename)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0644), fi.Mode().Perm())
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+}
+
+func TestEnvDocument(t *testing.T) {
+	const data = `# Service configuration
+
+NAME=app   
+  # The port to listen on
+PORT = 8080
+DEBUG=false
+PORT=9090
+
+# Secrets
+TOKEN='s3cr3t # not a comment'
+`
+
+	d, err := readconf.ParseEnvDocument([]byte(data))
+	require.NoError(t, err)
+	require.Equal(t, data, string(d.Bytes()))
+	require.Equal(t, []string{`NAME`, `PORT`, `DEBUG`, `TOKEN`}, d.Keys())
+
+	port, ok := d.Get(`PORT`)
+	require.True(t, ok)
+	require.Equal(t, `9090`, port)
+
+	_, ok = d.Get(`MISSING`)
+	require.False(t, ok)
+
+	require.NoError(t, d.Set(`DEBUG`, `true`))
+	require.NoError(t, d.Set(`GREETING`, `hello world`))
+	require.True(t, d.Delete(`PORT`))
+	require.False(t, d.Delete(`PORT`))
+	require.NoError(t, d.Rename(`NAME`, `SERVICE_NAME`))
+	require.EqualError(t, d.Rename(`NAME`, `X`), `key NAME not found`)
+	require.EqualError(t, d.Rename(`DEBUG`, `TOKEN`), `key TOKEN already exists`)
+	require.EqualError(t, d.Set(`A B`, `x`), `invalid key "A B"`)
+
+	require.Equal(t, `# Service configuration
+
+SERVICE_NAME=app   
+  # The port to listen on
+DEBUG=true
+
+# Secrets
+TOKEN='s3cr3t # not a comment'
+GREETING='hello world'
+`, string(d.Bytes()))
+
+	require.Equal(t, readconf.Map{
+		`SERVICE_NAME`: `app`,
+		`DEBUG`:        `true`,
+		`TOKEN`:        `s3cr3t # not a comment`,
+		`GREETING`:     `hello world`,
+	}, d.Map())
+
+	_, err = readconf.ParseEnvDocument([]byte("A=1\n=2"))
+	require.EqualError(t, err, `invalid empty key on line 2`)
+
+	d, err = readconf.ParseEnvDocument([]byte("A=1\r\nB=2"))
+	require.NoError(t, err)
+	require.NoError(t, d.Set(`A`, `0`))
+	require.NoError(t, d.Set(`C`, `3`))
+	require.Equal(t, "A=0\r\nB=2\r\nC=3", string(d.Bytes()))
+
+	d, err = readconf.ParseEnvDocument([]byte("A=1\r\n"))
+	require.NoError(t, err)
+	require.NoError(t, d.Set(`B`, `2`))
+	require.Equal(t, "A=1\r\nB=2\r\n", string(d.Bytes()))
+}
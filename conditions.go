@@ -0,0 +1,163 @@
+package readconf
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A condition of a required_if or enabled_by tag: either KEY=value, which
+// holds when KEY has the given value, or KEY, which holds when KEY is true.
+type condition struct {
+	key   string
+	value string
+	equal bool
+}
+
+func parseCondition(tag string) condition {
+	kvp := strings.SplitN(tag, `=`, 2)
+
+	c := condition{key: normalizeKey(kvp[0])}
+	if len(kvp) == 2 {
+		c.value = strings.TrimSpace(kvp[1])
+		c.equal = true
+	}
+
+	return c
+}
+
+// A condition doesn't hold if its key is not set.
+func (c condition) holds(lookup func(key string) (string, bool)) (bool, error) {
+	value, ok := lookup(c.key)
+	switch {
+	case !ok:
+		return false, nil
+	case c.equal:
+		return value == c.value, nil
+	}
+
+	on, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, &UnmarshalError{Key: c.key, Err: err}
+	}
+
+	return on, nil
+}
+
+// A struct field that is only configured when its condition holds.
+type toggle struct {
+	key       string
+	index     []int
+	condition condition
+}
+
+// Returns the keys of fields that need not be set, as well as the index
+// sequences of those fields and of disabled sections. Conditions are evaluated
+// against the resolved values. A section whose condition can't be evaluated is
+// disabled, and a field whose condition can't be evaluated need not be set, so
+// that the error returned for it is the only one.
+func evaluateConditions(
+	values Map,
+	options resolveOptions,
+	fields map[string][]int,
+	requiredIf map[string]condition,
+	toggles []toggle,
+) (map[string]bool, [][]int, []error) {
+	disabledKeys := map[string]bool{}
+	var disabled [][]int
+	var errs []error
+
+	if len(requiredIf) == 0 && len(toggles) == 0 {
+		return disabledKeys, disabled, nil
+	}
+
+	lookup := resolvedLookup(values, options)
+
+	for _, t := range toggles {
+		if isWithin(t.index, disabled) {
+			continue
+		}
+
+		on, err := t.condition.holds(lookup)
+		if err != nil {
+			errs = append(errs, &ConditionError{Key: t.key, Tag: _enabledByTag, Err: err})
+		} else if on {
+			continue
+		}
+
+		disabled = append(disabled, t.index)
+		for key, index := range fields {
+			if isWithin(index, disabled[len(disabled)-1:]) {
+				disabledKeys[key] = true
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(requiredIf))
+	for key := range requiredIf {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		c := requiredIf[key]
+		if disabledKeys[key] {
+			continue
+		}
+
+		if _, ok := values.Lookup(key); ok {
+			continue
+		}
+
+		on, err := c.holds(lookup)
+		switch {
+		case err != nil:
+			errs = append(errs, &ConditionError{Key: key, Tag: _requiredIfTag, Err: err})
+			disabledKeys[key] = true
+		case !on:
+			disabledKeys[key] = true
+			disabled = append(disabled, fields[key])
+		}
+	}
+
+	return disabledKeys, disabled, errs
+}
+
+// Returns true if index is equal to, or nested within, any of the given
+// index sequences.
+func isWithin(index []int, parents [][]int) bool {
+outer:
+	for _, parent := range parents {
+		if len(parent) > len(index) {
+			continue
+		}
+
+		for i := range parent {
+			if parent[i] != index[i] {
+				continue outer
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// Returns a function to look up values with their references resolved. The
+// values are copied on the first lookup, and each key is only resolved once it
+// is looked up.
+func resolvedLookup(values Map, options resolveOptions) func(key string) (string, bool) {
+	var r *resolver
+
+	return func(key string) (string, bool) {
+		if r == nil {
+			resolved := make(Map, len(values))
+			resolved.Merge(values)
+			r = newResolver(resolved, options)
+		}
+
+		r.resolve(key)
+		return r.m.Lookup(key)
+	}
+}
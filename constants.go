@@ -1,7 +1,20 @@
 package readconf
 
 const (
-	_configTag  = `config`
-	_defaultTag = `default`
-	_separator  = `__`
+	_configTag     = `config`
+	_defaultTag    = `default`
+	_secretTag     = `secret`
+	_aliasesTag    = `aliases`
+	_requiredIfTag = `required_if`
+	_enabledByTag  = `enabled_by`
+
+	_validateTag    = `validate`
+	_descriptionTag = `description`
+
+	_discriminatorTag = `discriminator`
+	_discriminator    = `TYPE`
+
+	_separator = `__`
 )
+
+const _redacted = `[REDACTED]`
@@ -0,0 +1,180 @@
+package readconf
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// FieldInfo describes a configuration key expected by a struct.
+//
+// Default is the value given by the default tag of the field or by the
+// DefaultConfig of a struct it belongs to, if HasDefault is set. Required is
+// set if Build fails when the key has no value; it is not set for keys with a
+// default, a required_if tag, or within a struct with an enabled_by tag.
+// Validate is the validate tag of the field, and Enum the values allowed by
+// its oneof validation or, for a discriminator key, the registered variants.
+type FieldInfo struct {
+	Key         string
+	Path        string
+	Type        string
+	Default     string
+	HasDefault  bool
+	Required    bool
+	RequiredIf  string
+	Validate    string
+	Description string
+	Secret      bool
+	Enum        []string
+}
+
+// Describe returns the configuration keys expected by the given struct, or
+// pointer to a struct, in the order its fields are declared. Interface fields
+// are not keys themselves, and are only described as structs if they hold a
+// pointer to one. Use Builder.Describe to include the discriminator keys of
+// variants.
+func Describe(target interface{}) ([]FieldInfo, error) {
+	fields, _, err := describe(target, nil)
+	return fields, err
+}
+
+// Describe is like the Describe function, but also describes the
+// discriminator key of each interface field with variants registered with the
+// builder, just before the keys of the variant it holds, if any.
+func (b *Builder) Describe(target interface{}) ([]FieldInfo, error) {
+	if b.hasError() {
+		return nil, b.err
+	}
+
+	fields, _, err := describe(target, b.variants)
+	return fields, err
+}
+
+// Like Describe, but also returns the type of each field.
+func describe(
+	target interface{},
+	variants map[reflect.Type]map[string]reflect.Type,
+) ([]FieldInfo, []reflect.Type, error) {
+	v := reflect.ValueOf(target)
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+
+	if v.Kind() != reflect.Struct {
+		return nil, nil, fmt.Errorf("expected struct or pointer to struct")
+	}
+
+	if !v.CanAddr() {
+		v1 := reflect.New(v.Type()).Elem()
+		v1.Set(v)
+		v = v1
+	}
+
+	var fields []FieldInfo
+	var types []reflect.Type
+	defaults := Map{}
+	configDefaults := Map{}
+
+	p := planFor(v.Type())
+	if p.defaultConfig {
+		configDefaults.Merge(defaultConfigValues(v, ``))
+	}
+
+	describeFields(p, v, planPrefix{}, false, variants, defaults, configDefaults, &fields, &types)
+	defaults.Merge(configDefaults)
+
+	for i, f := range fields {
+		if value, ok := defaults.Lookup(f.Key); ok {
+			fields[i].Default, fields[i].HasDefault = value, true
+			fields[i].Required = false
+		}
+	}
+
+	return fields, types, nil
+}
+
+func describeFields(
+	p *typePlan,
+	v reflect.Value,
+	prefix planPrefix,
+	optional bool,
+	variants map[reflect.Type]map[string]reflect.Type,
+	defaults, configDefaults Map,
+	fields *[]FieldInfo,
+	types *[]reflect.Type,
+) {
+	var toggled [][]int
+
+	for _, fp := range p.fields {
+		key := prefix.key1(fp.key)
+		fv := v.FieldByIndex(fp.field.Index)
+		optional := optional || isWithin(fp.field.Index, toggled)
+
+		if fp.defaultConfig {
+			configDefaults.Merge(defaultConfigValues(fv, key))
+		}
+
+		if vs, ok := variants[fv.Type()]; ok && fp.iface {
+			discriminator := discriminatorKey(key, fp.field)
+
+			*fields = append(*fields, FieldInfo{
+				Key:         discriminator,
+				Path:        prefix.namespace1(fp.namespace),
+				Type:        `string`,
+				Required:    !optional,
+				Description: fp.field.Tag.Get(_descriptionTag),
+				Enum:        variantNames(vs),
+			})
+			*types = append(*types, reflect.TypeOf(``))
+
+			if tag, ok := fp.field.Tag.Lookup(_defaultTag); ok {
+				defaults.Set(discriminator, tag)
+			}
+		} else if fp.direct && !fp.iface {
+			f := FieldInfo{
+				Key:         key,
+				Path:        prefix.namespace1(fp.namespace),
+				Type:        fp.field.Type.String(),
+				Required:    !optional && fp.requiredIf == nil,
+				RequiredIf:  fp.field.Tag.Get(_requiredIfTag),
+				Validate:    fp.field.Tag.Get(_validateTag),
+				Description: fp.field.Tag.Get(_descriptionTag),
+				Secret:      fp.secret,
+				Enum:        validateEnum(fp.field.Tag.Get(_validateTag)),
+			}
+
+			if fp.defaultTag != nil {
+				defaults.Set(key, *fp.defaultTag)
+			}
+
+			*fields = append(*fields, f)
+			*types = append(*types, fp.field.Type)
+		}
+
+		if fp.enabledBy != nil {
+			toggled = append(toggled, fp.field.Index)
+		}
+
+		if sv := indirectStruct(fv); fp.iface && sv.Kind() == reflect.Struct {
+			sp := planFor(sv.Type())
+			if sp.defaultConfig {
+				configDefaults.Merge(defaultConfigValues(sv, key))
+			}
+
+			inner := planPrefix{key: key, index: prefix.index1(fp.field.Index), namespace: prefix.namespace1(fp.namespace)}
+			describeFields(sp, sv, inner, optional, variants, defaults, configDefaults, fields, types)
+		}
+	}
+}
+
+// Returns the values allowed by the oneof validation in the given validate
+// tag, if any.
+func validateEnum(tag string) []string {
+	for _, rule := range strings.Split(tag, `,`) {
+		if strings.HasPrefix(rule, `oneof=`) {
+			return strings.Fields(strings.TrimPrefix(rule, `oneof=`))
+		}
+	}
+
+	return nil
+}
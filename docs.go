@@ -0,0 +1,157 @@
+package readconf
+
+import (
+	"bufio"
+	"html"
+	"io"
+	"sort"
+	"strings"
+)
+
+var _docColumns = []string{`Key`, `Type`, `Default`, `Required`, `Allowed values`, `Description`}
+
+// A group of keys in reference documentation, with the cells of their rows.
+type docSection struct {
+	name string
+	rows [][]string
+}
+
+// WriteMarkdown writes reference documentation for the keys expected by the
+// given struct, or pointer to a struct, as Markdown tables. Keys are sorted
+// and grouped by the struct they belong to, and defaults of secrets are
+// redacted.
+func WriteMarkdown(w io.Writer, target interface{}) error {
+	sections, err := docSections(target)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+
+	for i, s := range sections {
+		if i > 0 {
+			bw.WriteString("\n")
+		}
+
+		if s.name != `` {
+			bw.WriteString(`## ` + s.name + "\n\n")
+		}
+
+		bw.WriteString(`| ` + strings.Join(_docColumns, ` | `) + " |\n")
+		bw.WriteString(`|` + strings.Repeat(` --- |`, len(_docColumns)) + "\n")
+
+		for _, row := range s.rows {
+			cells := make([]string, len(row))
+			for j, cell := range row {
+				cells[j] = markdownCell(cell, j < 3 || j == 4)
+			}
+
+			bw.WriteString(`| ` + strings.Join(cells, ` | `) + " |\n")
+		}
+	}
+
+	return bw.Flush()
+}
+
+// WriteHTML is like WriteMarkdown, but writes HTML tables.
+func WriteHTML(w io.Writer, target interface{}) error {
+	sections, err := docSections(target)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+
+	for _, s := range sections {
+		if s.name != `` {
+			bw.WriteString(`<h2>` + html.EscapeString(s.name) + "</h2>\n")
+		}
+
+		bw.WriteString("<table>\n<thead>\n<tr>")
+		for _, c := range _docColumns {
+			bw.WriteString(`<th>` + html.EscapeString(c) + `</th>`)
+		}
+		bw.WriteString("</tr>\n</thead>\n<tbody>\n")
+
+		for _, row := range s.rows {
+			bw.WriteString(`<tr>`)
+			for j, cell := range row {
+				cell = html.EscapeString(cell)
+				if cell != `` && (j < 3 || j == 4) {
+					cell = `<code>` + cell + `</code>`
+				}
+
+				bw.WriteString(`<td>` + cell + `</td>`)
+			}
+			bw.WriteString("</tr>\n")
+		}
+
+		bw.WriteString("</tbody>\n</table>\n")
+	}
+
+	return bw.Flush()
+}
+
+func docSections(target interface{}) ([]docSection, error) {
+	fields, err := Describe(target)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(fields, func(i, j int) bool {
+		gi, gj := keyGroup(fields[i].Key), keyGroup(fields[j].Key)
+		if gi != gj {
+			return gi < gj
+		}
+
+		return fields[i].Key < fields[j].Key
+	})
+
+	var sections []docSection
+
+	for _, f := range fields {
+		if g := keyGroup(f.Key); len(sections) == 0 || sections[len(sections)-1].name != g {
+			sections = append(sections, docSection{name: g})
+		}
+
+		value := ``
+		if f.HasDefault {
+			value = f.Default
+			if f.Secret {
+				value = _redacted
+			}
+		}
+
+		required := `no`
+		switch {
+		case f.Required:
+			required = `yes`
+		case f.RequiredIf != ``:
+			required = `if ` + f.RequiredIf
+		}
+
+		s := &sections[len(sections)-1]
+		s.rows = append(s.rows, []string{
+			f.Key,
+			f.Type,
+			value,
+			required,
+			strings.Join(f.Enum, `, `),
+			f.Description,
+		})
+	}
+
+	return sections, nil
+}
+
+// Escapes a cell of a Markdown table, optionally as code.
+func markdownCell(s string, code bool) string {
+	s = strings.Replace(s, `|`, `\|`, -1)
+	s = strings.Replace(s, "\n", ` `, -1)
+
+	if code && s != `` {
+		return "`" + s + "`"
+	}
+
+	return s
+}
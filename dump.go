@@ -0,0 +1,141 @@
+package readconf
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Dump builds the target and writes a table of every configuration key it
+// uses, with its final value and source. Secret values are redacted.
+func (b *Builder) Dump(w io.Writer, target interface{}) error {
+	entries, err := b.dumpEntries(target)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tVALUE\tSOURCE")
+
+	for _, p := range entries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", p.Key, p.Value, p.Source)
+	}
+
+	return tw.Flush()
+}
+
+// DumpJSON is like Dump, but writes a JSON array of objects with the fields
+// "key", "value" and "source".
+func (b *Builder) DumpJSON(w io.Writer, target interface{}) error {
+	entries, err := b.dumpEntries(target)
+	if err != nil {
+		return err
+	}
+
+	type jsonEntry struct {
+		Key    string `json:"key"`
+		Value  string `json:"value"`
+		Source string `json:"source"`
+	}
+
+	out := make([]jsonEntry, len(entries))
+	for i, p := range entries {
+		out[i] = jsonEntry{Key: p.Key, Value: p.Value, Source: p.Source.String()}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent(``, `  `)
+	return enc.Encode(out)
+}
+
+// Builds the target, and returns the provenance of the keys it uses from that
+// call, regardless of other calls made concurrently.
+func (b *Builder) dumpEntries(target interface{}) ([]Provenance, error) {
+	res, err := b.record(b.build(target))
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]Provenance, 0, len(res.keys))
+
+	for _, key := range res.keys {
+		p, ok := res.provenance[key]
+		if !ok {
+			continue
+		}
+
+		if p.Secret {
+			p.Value = _redacted
+		}
+
+		out = append(out, p)
+	}
+
+	return out, nil
+}
+
+func isSecretField(f reflect.StructField) bool {
+	if reflect.PtrTo(f.Type).Implements(_secretValueType) {
+		return true
+	}
+
+	tag, ok := f.Tag.Lookup(_secretTag)
+	if !ok {
+		return false
+	}
+
+	secret, _ := strconv.ParseBool(tag)
+	return secret
+}
+
+// Returns the given secret keys together with every key whose value
+// references one of them, directly or indirectly. Each value is parsed at most
+// once, to find the keys referencing each key.
+func taintedKeys(m Map, secrets map[string]bool) map[string]bool {
+	tainted := make(map[string]bool, len(secrets))
+	queue := make([]string, 0, len(secrets))
+	for k, v := range secrets {
+		if v {
+			tainted[k] = true
+			queue = append(queue, k)
+		}
+	}
+
+	if len(queue) == 0 {
+		return tainted
+	}
+
+	referrers := map[string][]string{}
+	for key, value := range m {
+		if !strings.Contains(value, `$`) {
+			continue
+		}
+
+		tokens, err := parseValue(value)
+		if err != nil {
+			continue
+		}
+
+		for _, ref := range referencedKeys(tokens) {
+			referrers[ref] = append(referrers[ref], key)
+		}
+	}
+
+	for len(queue) > 0 {
+		key := queue[0]
+		queue = queue[1:]
+
+		for _, referrer := range referrers[key] {
+			if !tainted[referrer] {
+				tainted[referrer] = true
+				queue = append(queue, referrer)
+			}
+		}
+	}
+
+	return tainted
+}
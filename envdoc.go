@@ -0,0 +1,208 @@
+package readconf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// EnvDocument is an env file, as read by MergeData, that can be edited
+// without losing its comments, blank lines and the order of its keys. Lines
+// that are not edited are written back exactly as they were read.
+type EnvDocument struct {
+	lines []envLine
+	crlf  bool
+}
+
+// A line of an env document. Key is empty for blank lines and comments.
+type envLine struct {
+	raw   string
+	key   string
+	value string
+}
+
+// ParseEnvDocument parses the given contents of an env file.
+func ParseEnvDocument(data []byte) (*EnvDocument, error) {
+	d := &EnvDocument{}
+
+	for i, raw := range strings.Split(string(data), "\n") {
+		if i == 0 && strings.HasSuffix(raw, "\r") {
+			d.crlf = true
+		}
+
+		key, value, ok := parseEnvLine([]byte(raw))
+		if ok && key == `` {
+			return nil, fmt.Errorf(`invalid empty key on line %d`, i+1)
+		}
+
+		d.lines = append(d.lines, envLine{raw: raw, key: key, value: value})
+	}
+
+	return d, nil
+}
+
+// ReadEnvDocument reads and parses the named env file.
+func ReadEnvDocument(filename string) (*EnvDocument, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseEnvDocument(data)
+}
+
+// Keys returns the keys set by the document, in the order they first appear.
+func (d *EnvDocument) Keys() []string {
+	var keys []string
+	seen := map[string]bool{}
+
+	for _, l := range d.lines {
+		if l.key != `` && !seen[l.key] {
+			keys = append(keys, l.key)
+			seen[l.key] = true
+		}
+	}
+
+	return keys
+}
+
+// Get returns the value of the given key. If the key is set more than once,
+// the last value is returned, as it is the one MergeData uses.
+func (d *EnvDocument) Get(key string) (string, bool) {
+	if i := d.last(key); i >= 0 {
+		return d.lines[i].value, true
+	}
+
+	return ``, false
+}
+
+// Set sets the value of the given key, by rewriting the line that sets it, or
+// adding a line at the end of the document if there is none.
+func (d *EnvDocument) Set(key, value string) error {
+	if err := validateEnvKey(key); err != nil {
+		return err
+	}
+
+	l := envLine{raw: key + `=` + quoteValue(value), key: key, value: value}
+	if i := d.last(key); i >= 0 {
+		if strings.HasSuffix(d.lines[i].raw, "\r") {
+			l.raw += "\r"
+		}
+
+		d.lines[i] = l
+		return nil
+	}
+
+	n := len(d.lines)
+
+	// Keep the document ending in a newline if it did.
+	if n > 0 && d.lines[n-1].key == `` && strings.TrimSpace(d.lines[n-1].raw) == `` {
+		if d.crlf {
+			l.raw += "\r"
+		}
+
+		d.lines = append(d.lines[:n-1], l, d.lines[n-1])
+		return nil
+	}
+
+	if n > 0 && d.crlf {
+		d.lines[n-1].raw += "\r"
+	}
+
+	d.lines = append(d.lines, l)
+	return nil
+}
+
+// Delete removes every line that sets the given key, and returns false if
+// there were none.
+func (d *EnvDocument) Delete(key string) bool {
+	lines := d.lines[:0]
+	for _, l := range d.lines {
+		if l.key != key {
+			lines = append(lines, l)
+		}
+	}
+
+	deleted := len(lines) < len(d.lines)
+	d.lines = lines
+	return deleted
+}
+
+// Rename changes the key of every line that sets the key from to the key to,
+// keeping their values and positions. It fails if to is already set.
+func (d *EnvDocument) Rename(from, to string) error {
+	if err := validateEnvKey(to); err != nil {
+		return err
+	}
+
+	switch {
+	case d.last(from) < 0:
+		return fmt.Errorf(`key %s not found`, from)
+	case from != to && d.last(to) >= 0:
+		return fmt.Errorf(`key %s already exists`, to)
+	}
+
+	for i, l := range d.lines {
+		if l.key == from {
+			start := strings.Index(l.raw, from)
+			l.raw = l.raw[:start] + to + l.raw[start+len(from):]
+			l.key = to
+			d.lines[i] = l
+		}
+	}
+
+	return nil
+}
+
+// Map returns the values set by the document, as MergeData would read them.
+func (d *EnvDocument) Map() Map {
+	m := Map{}
+	for _, l := range d.lines {
+		if l.key != `` {
+			m[l.key] = l.value
+		}
+	}
+
+	return m
+}
+
+// Bytes returns the contents of the document.
+func (d *EnvDocument) Bytes() []byte {
+	var buf bytes.Buffer
+	for i, l := range d.lines {
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+
+		buf.WriteString(l.raw)
+	}
+
+	return buf.Bytes()
+}
+
+// WriteTo writes the contents of the document.
+func (d *EnvDocument) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(d.Bytes())
+	return int64(n), err
+}
+
+// WriteFile atomically replaces the named file with the contents of the
+// document, as Map.WriteEnvFile does.
+func (d *EnvDocument) WriteFile(filename string) error {
+	return writeFileAtomic(filename, func(w io.Writer) error {
+		_, err := d.WriteTo(w)
+		return err
+	})
+}
+
+func (d *EnvDocument) last(key string) int {
+	for i := len(d.lines) - 1; i >= 0; i-- {
+		if d.lines[i].key == key {
+			return i
+		}
+	}
+
+	return -1
+}
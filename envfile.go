@@ -0,0 +1,248 @@
+package readconf
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// WriteTo writes the map as lines of the form KEY=value, sorted by key, which
+// MergeData and MergeFile read back as the same map. Values are quoted if they
+// contain anything but letters, digits and a few safe punctuation characters:
+// within single quotes if possible, or else within double quotes, escaping
+// backslashes, double quotes, backticks and dollar signs. Values containing
+// control characters or invalid UTF-8 are written as ANSI-C quoted strings,
+// like $'a\nb'. MergeData and MergeFile unquote any value quoted in one of
+// these ways, whether or not WriteTo wrote it.
+func (m Map) WriteTo(w io.Writer) (int64, error) {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		if err := validateEnvKey(key); err != nil {
+			return 0, err
+		}
+
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+
+	for _, key := range keys {
+		bw.WriteString(key + `=` + quoteValue(m[key]) + "\n")
+	}
+
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// WriteEnvFile writes the map to the named file as WriteTo does. The file is
+// replaced atomically, by writing to a temporary file in the same directory
+// and renaming it. A new file is only readable and writable by its owner; an
+// existing file keeps its permissions.
+func (m Map) WriteEnvFile(filename string) error {
+	return writeFileAtomic(filename, func(w io.Writer) error {
+		_, err := m.WriteTo(w)
+		return err
+	})
+}
+
+func writeFileAtomic(filename string, write func(w io.Writer) error) (err error) {
+	mode := os.FileMode(0600)
+	if fi, err := os.Stat(filename); err == nil {
+		mode = fi.Mode().Perm()
+	}
+
+	dir, base := filepath.Split(filename)
+	if dir == `` {
+		dir = `.`
+	}
+
+	f, err := ioutil.TempFile(dir, `.`+base+`.tmp`)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	if err = write(f); err != nil {
+		return err
+	}
+
+	if err = f.Chmod(mode); err != nil {
+		return err
+	}
+
+	if err = f.Sync(); err != nil {
+		return err
+	}
+
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), filename)
+}
+
+// Returns an error if the key can't be written to an env file and read back.
+func validateEnvKey(key string) error {
+	if key == `` || key[0] == '#' || strings.ContainsAny(key, "=\"' \t\r\n") {
+		return fmt.Errorf(`invalid key %q`, key)
+	}
+
+	return nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// Quotes values as a shell would need them: within single quotes if possible,
+// within double quotes if they contain a single quote, or as ANSI-C quoted
+// strings like $'line\n' if they contain control characters or invalid UTF-8.
+func quoteValue(s string) string {
+	plain, printable := s != ``, true
+
+	for i := 0; i < len(s); {
+		c, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+
+		switch {
+		case c < ' ' || c == 0x7f || c == utf8.RuneError && size == 1:
+			plain, printable = false, false
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.ContainsRune(`_-.,/:@%+=~^`, c):
+		default:
+			plain = false
+		}
+	}
+
+	switch {
+	case plain || s == ``:
+		return s
+	case printable && !strings.Contains(s, `'`):
+		return `'` + s + `'`
+	}
+
+	var b strings.Builder
+
+	if printable {
+		b.WriteByte('"')
+		for i := 0; i < len(s); i++ {
+			if strings.IndexByte("\\\"`$", s[i]) >= 0 {
+				b.WriteByte('\\')
+			}
+			b.WriteByte(s[i])
+		}
+		b.WriteByte('"')
+
+		return b.String()
+	}
+
+	b.WriteString(`$'`)
+	for i := 0; i < len(s); {
+		c, size := utf8.DecodeRuneInString(s[i:])
+
+		switch {
+		case c == '\\' || c == '\'':
+			b.WriteByte('\\')
+			b.WriteRune(c)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c == '\r':
+			b.WriteString(`\r`)
+		case c == '\t':
+			b.WriteString(`\t`)
+		case c < ' ' || c == 0x7f || c == utf8.RuneError && size == 1:
+			fmt.Fprintf(&b, `\x%02x`, s[i])
+		default:
+			b.WriteString(s[i : i+size])
+		}
+
+		i += size
+	}
+	b.WriteByte('\'')
+
+	return b.String()
+}
+
+// Removes a pair of quotes around the whole value, as a shell would: the
+// contents of single quotes are taken as they are; within double quotes, a
+// backslash escapes a backslash, double quote, backtick or dollar sign; and
+// within ANSI-C quotes, like $'a\nb', it escapes a backslash or single quote,
+// or stands for a newline, carriage return or tab as \n, \r and \t, or for
+// any byte as \xHH. Other backslashes are kept. Values that aren't quoted are
+// returned as they are.
+func unquoteValue(s string) string {
+	switch {
+	case len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'':
+		return s[1 : len(s)-1]
+	case len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"':
+		return unescapeValue(s[1:len(s)-1], false)
+	case len(s) >= 3 && strings.HasPrefix(s, `$'`) && s[len(s)-1] == '\'':
+		return unescapeValue(s[2:len(s)-1], true)
+	}
+
+	return s
+}
+
+// Replaces escape sequences with the characters they stand for, within
+// double quotes or, if ansi is set, within ANSI-C quotes.
+func unescapeValue(s string, ansi bool) string {
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		next := s[i+1]
+
+		switch {
+		case !ansi && strings.IndexByte("\\\"`$", next) >= 0,
+			ansi && (next == '\\' || next == '\''):
+			b.WriteByte(next)
+		case ansi && next == 'n':
+			b.WriteByte('\n')
+		case ansi && next == 'r':
+			b.WriteByte('\r')
+		case ansi && next == 't':
+			b.WriteByte('\t')
+		case ansi && next == 'x' && i+3 < len(s) && isHex(s[i+2]) && isHex(s[i+3]):
+			n, _ := strconv.ParseUint(s[i+2:i+4], 16, 8)
+			b.WriteByte(byte(n))
+			i += 2
+		default:
+			b.WriteByte('\\')
+			continue
+		}
+
+		i++
+	}
+
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
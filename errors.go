@@ -0,0 +1,238 @@
+package readconf
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MissingKeysError is returned by Build when configuration keys without a
+// default value have not been set.
+type MissingKeysError struct {
+	Keys []string
+}
+
+func (e *MissingKeysError) Error() string {
+	plural := ""
+	if len(e.Keys) > 1 {
+		plural = "s"
+	}
+
+	return fmt.Sprintf(
+		"missing %d configuration key%s: %s",
+		len(e.Keys), plural,
+		strings.Join(e.Keys, ", "))
+}
+
+// UnmarshalError is returned when the value of a configuration key cannot be
+// unmarshalled into its field. Source is the source of the offending value,
+// where known.
+type UnmarshalError struct {
+	Key    string
+	Source Source
+	Err    error
+}
+
+func (e *UnmarshalError) Error() string {
+	return fmt.Sprintf(`configuration key "%s": %v`, e.Key, e.Err)
+}
+
+func (e *UnmarshalError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationError is returned when the built configuration fails validation.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	fields := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		fields[i] = f.Error()
+	}
+
+	return fmt.Sprintf(`validation failed: %s`, strings.Join(fields, `; `))
+}
+
+// FieldError describes a single failed validation. Tag and Param are those of
+// the failed validation, such as "min" and "2". Value is the value of the
+// field, or a redacted placeholder for secrets. Message describes the problem
+// in words, without naming the key.
+type FieldError struct {
+	Key     string
+	Tag     string
+	Param   string
+	Value   interface{}
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return e.Key + `: ` + e.Message
+}
+
+// ReferenceError is returned when a reference in a value cannot be resolved.
+// Key is the key whose value contains the reference and Ref the referenced
+// key. Offset is the position of the reference within the value of Key. Cycle
+// is set if the reference is cyclic, and lists the keys that form the cycle,
+// beginning and ending with Ref. Message is the message given by a
+// ${KEY:?message} reference or, if Ref is empty, describes why the reference
+// could not be parsed.
+type ReferenceError struct {
+	Key     string
+	Ref     string
+	Offset  int
+	Cycle   []string
+	Message string
+}
+
+func (e *ReferenceError) Error() string {
+	switch {
+	case len(e.Cycle) > 0:
+		return fmt.Sprintf(`cyclic reference: %s`, strings.Join(e.Cycle, `, `))
+	case e.Ref == ``:
+		return fmt.Sprintf(`invalid reference in %s at offset %d: %s`, e.Key, e.Offset, e.Message)
+	case e.Message != ``:
+		return fmt.Sprintf(`key %s referenced by %s: %s`, e.Ref, e.Key, e.Message)
+	}
+
+	return fmt.Sprintf(`key %s referenced by %s not found`, e.Ref, e.Key)
+}
+
+// UnknownKeysError is returned by strict builds when keys are set that don't
+// correspond to any field. Suggestions maps unknown keys to the known keys
+// they are likely typos of.
+type UnknownKeysError struct {
+	Keys        []string
+	Suggestions map[string]string
+}
+
+func (e *UnknownKeysError) Error() string {
+	descriptions := make([]string, len(e.Keys))
+	for i, key := range e.Keys {
+		descriptions[i] = key
+		if suggestion := e.Suggestions[key]; suggestion != `` {
+			descriptions[i] += fmt.Sprintf(` (did you mean %s?)`, suggestion)
+		}
+	}
+
+	plural := ""
+	if len(e.Keys) > 1 {
+		plural = "s"
+	}
+
+	return fmt.Sprintf(
+		"unknown %d configuration key%s: %s",
+		len(e.Keys), plural,
+		strings.Join(descriptions, ", "))
+}
+
+// AliasConflictError is returned when a key and its alias, or two aliases of
+// the same key, are set to different values. Key is the one that takes
+// precedence, which is the key itself if it is set.
+type AliasConflictError struct {
+	Key   string
+	Alias string
+}
+
+func (e *AliasConflictError) Error() string {
+	return fmt.Sprintf(`conflicting values for configuration keys %s and %s`, e.Key, e.Alias)
+}
+
+// ConditionError is returned when the condition of a required_if or
+// enabled_by tag cannot be evaluated. Key is the key of the field, or the key
+// prefix of the struct, that has the tag.
+type ConditionError struct {
+	Key string
+	Tag string
+	Err error
+}
+
+func (e *ConditionError) Error() string {
+	return fmt.Sprintf(`invalid %s condition of %s: %v`, e.Tag, e.Key, e.Err)
+}
+
+func (e *ConditionError) Unwrap() error {
+	return e.Err
+}
+
+// HookError is returned when the Finalize or Validate method of a
+// configuration struct fails. Key is the key prefix of the struct, which is
+// empty for the target itself.
+type HookError struct {
+	Key  string
+	Hook string
+	Err  error
+}
+
+func (e *HookError) Error() string {
+	if e.Key == `` {
+		return e.Err.Error()
+	}
+
+	return fmt.Sprintf(`%s: %v`, e.Key, e.Err)
+}
+
+func (e *HookError) Unwrap() error {
+	return e.Err
+}
+
+// Errors is returned by Build when errors are aggregated. Each error is one of
+// the error types above, and concerns a single key.
+type Errors []error
+
+func (e Errors) Error() string {
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = err.Error()
+	}
+
+	plural := ""
+	if len(e) > 1 {
+		plural = "s"
+	}
+
+	return fmt.Sprintf(
+		"%d configuration error%s:\n\t%s",
+		len(e), plural,
+		strings.Join(lines, "\n\t"))
+}
+
+func (e Errors) Unwrap() []error {
+	return e
+}
+
+func (e Errors) sort() {
+	sort.SliceStable(e, func(i, j int) bool {
+		return errorKey(e[i]) < errorKey(e[j])
+	})
+}
+
+func errorKey(err error) string {
+	switch err := err.(type) {
+	case *MissingKeysError:
+		if len(err.Keys) > 0 {
+			return err.Keys[0]
+		}
+	case *UnmarshalError:
+		return err.Key
+	case *ValidationError:
+		if len(err.Fields) > 0 {
+			return err.Fields[0].Key
+		}
+	case *ReferenceError:
+		return err.Key
+	case *HookError:
+		return err.Key
+	case *UnknownKeysError:
+		if len(err.Keys) > 0 {
+			return err.Keys[0]
+		}
+	case *AliasConflictError:
+		return err.Key
+	case *ConditionError:
+		return err.Key
+	}
+
+	return ``
+}
@@ -0,0 +1,89 @@
+// +build go1.13
+
+package readconf_test
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/tetratom/readconf"
+)
+
+func TestMissingKeysError(t *testing.T) {
+	var conf configWithPartialDefaults
+	err := b().Build(&conf)
+
+	var target *readconf.MissingKeysError
+	require.True(t, errors.As(err, &target))
+	require.Equal(t, []string{`EMBEDDED_BAR`, `FOO`, `NESTED__FOO`}, target.Keys)
+	require.EqualError(t, err, `missing 3 configuration keys: EMBEDDED_BAR, FOO, NESTED__FOO`)
+}
+
+func TestUnmarshalError(t *testing.T) {
+	var conf struct {
+		Foo int
+	}
+
+	err := b().MergeData([]byte(`FOO=bar`)).Build(&conf)
+	require.EqualError(t, err, `unmarshal value: configuration key "FOO": `+
+		`strconv.ParseInt: parsing "bar": invalid syntax`)
+
+	var target *readconf.UnmarshalError
+	require.True(t, errors.As(err, &target))
+	require.Equal(t, `FOO`, target.Key)
+	require.Equal(t, readconf.Source{Kind: readconf.SourceData, Line: 1}, target.Source)
+
+	var numErr *strconv.NumError
+	require.True(t, errors.As(err, &numErr))
+}
+
+func TestValidationError(t *testing.T) {
+	var conf validationFailureConf
+	err := b().Set(`BAR`, `b`).Build(&conf)
+	require.EqualError(t, err, "validation failed: "+
+		"BAR: must be at least 2 characters (got 1); "+
+		"FOO: must be at least 2 characters (got 1)")
+
+	var target *readconf.ValidationError
+	require.True(t, errors.As(err, &target))
+	require.Equal(t, []readconf.FieldError{
+		{Key: `BAR`, Tag: `min`, Param: `2`, Value: `b`, Message: `must be at least 2 characters (got 1)`},
+		{Key: `FOO`, Tag: `min`, Param: `2`, Value: `a`, Message: `must be at least 2 characters (got 1)`},
+	}, target.Fields)
+}
+
+func TestReferenceError(t *testing.T) {
+	t.Run("missing", func(t *testing.T) {
+		var conf struct {
+			Foo string `default:"${BAR}"`
+		}
+
+		err := b().Build(&conf)
+		require.EqualError(t, err, `resolve values: key BAR referenced by FOO not found`)
+
+		var target *readconf.ReferenceError
+		require.True(t, errors.As(err, &target))
+		require.Equal(t, &readconf.ReferenceError{Key: `FOO`, Ref: `BAR`}, target)
+	})
+
+	t.Run("cycle", func(t *testing.T) {
+		var conf struct {
+			Bar string `default:"${FOO}"`
+			Foo string `default:"${BAR}"`
+		}
+
+		err := b().Build(&conf)
+		require.EqualError(t, err, `resolve values: cyclic reference: BAR, FOO, BAR`)
+
+		var target *readconf.ReferenceError
+		require.True(t, errors.As(err, &target))
+		require.Equal(t, &readconf.ReferenceError{
+			Key:   `FOO`,
+			Ref:   `BAR`,
+			Cycle: []string{`BAR`, `FOO`, `BAR`},
+		}, target)
+	})
+}
@@ -0,0 +1,95 @@
+package readconf
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"strings"
+)
+
+// WriteEnvExample writes an example env file for the given struct, or pointer
+// to a struct, with a line for every key it expects. Keys are set to their
+// defaults, quoted as Map.WriteTo quotes values, except for secrets and keys
+// without a default, which are left empty, and are preceded by comments
+// holding their descriptions and validation rules. Keys of the target itself
+// come first, and keys of nested structs follow, grouped under a comment
+// naming the struct.
+func WriteEnvExample(w io.Writer, target interface{}) error {
+	fields, err := Describe(target)
+	if err != nil {
+		return err
+	}
+
+	// Fields declared after a nested struct are not left under its comment.
+	sort.SliceStable(fields, func(i, j int) bool {
+		return keyGroup(fields[i].Key) < keyGroup(fields[j].Key)
+	})
+
+	bw := bufio.NewWriter(w)
+	group := ``
+
+	for i, f := range fields {
+		rules := exampleRules(f)
+
+		if g := keyGroup(f.Key); i == 0 || g != group {
+			if i > 0 {
+				bw.WriteString("\n")
+			}
+
+			if g != `` {
+				bw.WriteString(`# ` + g + "\n\n")
+			}
+
+			group = g
+		} else if f.Description != `` || len(rules) > 0 {
+			bw.WriteString("\n")
+		}
+
+		if f.Description != `` {
+			bw.WriteString(`# ` + f.Description + "\n")
+		}
+
+		if len(rules) > 0 {
+			bw.WriteString(`# ` + strings.Join(rules, `; `) + "\n")
+		}
+
+		value := ``
+		if f.HasDefault && !f.Secret {
+			value = f.Default
+		}
+
+		bw.WriteString(f.Key + `=` + quoteValue(value) + "\n")
+	}
+
+	return bw.Flush()
+}
+
+// Returns the key of the struct the given key belongs to.
+func keyGroup(key string) string {
+	if i := strings.LastIndex(key, _separator); i >= 0 {
+		return key[:i]
+	}
+
+	return ``
+}
+
+func exampleRules(f FieldInfo) []string {
+	var rules []string
+
+	switch {
+	case f.Required:
+		rules = append(rules, `required`)
+	case f.RequiredIf != ``:
+		rules = append(rules, `required if `+f.RequiredIf)
+	}
+
+	if f.Secret {
+		rules = append(rules, `secret`)
+	}
+
+	if f.Validate != `` {
+		rules = append(rules, `validate: `+f.Validate)
+	}
+
+	return rules
+}
@@ -0,0 +1,41 @@
+package readconf
+
+import (
+	"encoding/base64"
+	"net/url"
+	"strings"
+)
+
+// TransformFunc transforms the value of a reference, as in ${NAME|lower}. The
+// argument is what follows a colon after the name of the function, as in
+// ${LIST|join:,}, or empty.
+type TransformFunc func(value, arg string) (string, error)
+
+var _builtinFuncs = map[string]TransformFunc{
+	`lower`:        func(v, _ string) (string, error) { return strings.ToLower(v), nil },
+	`upper`:        func(v, _ string) (string, error) { return strings.ToUpper(v), nil },
+	`trim`:         func(v, _ string) (string, error) { return strings.TrimSpace(v), nil },
+	`base64encode`: func(v, _ string) (string, error) { return base64.StdEncoding.EncodeToString([]byte(v)), nil },
+	`base64decode`: func(v, _ string) (string, error) { return resolveBase64(v) },
+	`urlencode`:    func(v, _ string) (string, error) { return url.QueryEscape(v), nil },
+	`join`:         func(v, sep string) (string, error) { return strings.Join(strings.Fields(v), sep), nil },
+}
+
+// RegisterFunc registers a function to transform the values of references, as
+// in ${NAME|name} or ${NAME|name:argument}. Functions are applied from left to
+// right, after any operator. The built-in functions are lower, upper, trim,
+// base64encode, base64decode, urlencode, and join, which joins the
+// whitespace-separated items of a value with its argument. They may be
+// overridden.
+func (b *Builder) RegisterFunc(name string, f TransformFunc) *Builder {
+	if b.hasError() {
+		return b
+	}
+
+	if b.funcs == nil {
+		b.funcs = map[string]TransformFunc{}
+	}
+
+	b.funcs[name] = f
+	return b
+}
@@ -0,0 +1,59 @@
+package readconf
+
+import (
+	"reflect"
+)
+
+// A struct within the target whose hooks are to be called. Embedded structs
+// are not sections of their own, as their methods are promoted to the struct
+// they are embedded in.
+type section struct {
+	key   string
+	index []int
+	value reflect.Value
+}
+
+func enabledSections(sections []section, disabled [][]int) []section {
+	out := make([]section, 0, len(sections))
+	for _, s := range sections {
+		if !isWithin(s.index, disabled) {
+			out = append(out, s)
+		}
+	}
+
+	return out
+}
+
+// Calls Finalize on every section that implements Finalizer, nested sections
+// first, and stops at the first error.
+func finalizeSections(sections []section) error {
+	for i := len(sections) - 1; i >= 0; i-- {
+		s := sections[i]
+
+		if f, ok := s.value.Addr().Interface().(Finalizer); ok {
+			if err := f.Finalize(); err != nil {
+				return &HookError{Key: s.key, Hook: `Finalize`, Err: err}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Calls Validate on every section that implements Validatable, nested
+// sections first, and returns every error.
+func validateSections(sections []section) []error {
+	var errs []error
+
+	for i := len(sections) - 1; i >= 0; i-- {
+		s := sections[i]
+
+		if v, ok := s.value.Addr().Interface().(Validatable); ok {
+			if err := v.Validate(); err != nil {
+				errs = append(errs, &HookError{Key: s.key, Hook: `Validate`, Err: err})
+			}
+		}
+	}
+
+	return errs
+}
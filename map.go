@@ -27,7 +27,9 @@ func (m Map) Set(key, value string) {
 
 func (m Map) Unmarshal(key string, v interface{}) (err error) {
 	defer func() {
-		err = wrapError(err, "configuration key \"%s\"", key)
+		if err != nil {
+			err = &UnmarshalError{Key: key, Err: err}
+		}
 	}()
 
 	vt := reflect.TypeOf(v)
@@ -43,24 +45,42 @@ func (m Map) Unmarshal(key string, v interface{}) (err error) {
 	}
 
 	switch {
-	case vt.Implements(_unmarshalerType):
-		return vv.Interface().(Unmarshaler).UnmarshalConfig(value)
-	case vt.Implements(_textUnmarshalerType):
-		return vv.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value))
+	case reflect.PtrTo(vt).Implements(_unmarshalerType):
+		return v.(Unmarshaler).UnmarshalConfig(value)
+	case reflect.PtrTo(vt).Implements(_textUnmarshalerType):
+		return v.(encoding.TextUnmarshaler).UnmarshalText([]byte(value))
 	default:
 		switch vt.Kind() {
 		case reflect.String:
 			vv.SetString(value)
 			return nil
-		case reflect.Int, reflect.Int64:
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 			var iv int64
-			iv, err = strconv.ParseInt(value, 10, 64)
+			iv, err = strconv.ParseInt(value, 10, vt.Bits())
 			if err != nil {
 				return err
 			}
 
 			vv.SetInt(iv)
 			return nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			var uv uint64
+			uv, err = strconv.ParseUint(value, 10, vt.Bits())
+			if err != nil {
+				return err
+			}
+
+			vv.SetUint(uv)
+			return nil
+		case reflect.Float32, reflect.Float64:
+			var fv float64
+			fv, err = strconv.ParseFloat(value, vt.Bits())
+			if err != nil {
+				return err
+			}
+
+			vv.SetFloat(fv)
+			return nil
 		case reflect.Bool:
 			var bv bool
 			bv, err = strconv.ParseBool(value)
@@ -0,0 +1,130 @@
+package readconf
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Marshal returns the values of the fields of the given struct, or pointer to
+// a struct, under the keys Build would read them from. Fields are encoded with
+// their MarshalConfig or MarshalText methods, or formatted if they are
+// strings, numbers or booleans. Dollar signs are escaped as $$, so that values
+// are read back as they are. Interface fields are marshalled as the struct
+// they hold, if any, but the discriminator keys of variants are not included;
+// use Builder.Marshal for those.
+func Marshal(target interface{}) (Map, error) {
+	return marshal(target, nil)
+}
+
+// Marshal is like the Marshal function, but also sets the discriminator keys
+// of interface fields that hold variants registered with the builder, so that
+// the values can be read back by the same builder. It fails if such a field
+// holds a struct that is not a registered variant.
+func (b *Builder) Marshal(target interface{}) (Map, error) {
+	if b.hasError() {
+		return nil, b.err
+	}
+
+	return marshal(target, b.variants)
+}
+
+func marshal(target interface{}, variants map[reflect.Type]map[string]reflect.Type) (Map, error) {
+	v := reflect.ValueOf(target)
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("expected struct or pointer to struct")
+	}
+
+	if !v.CanAddr() {
+		v1 := reflect.New(v.Type()).Elem()
+		v1.Set(v)
+		v = v1
+	}
+
+	m := Map{}
+	if err := marshalFields(m, variants, planFor(v.Type()), v, planPrefix{}); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func marshalFields(
+	m Map,
+	variants map[reflect.Type]map[string]reflect.Type,
+	p *typePlan,
+	v reflect.Value,
+	prefix planPrefix,
+) error {
+	for _, fp := range p.fields {
+		key := prefix.key1(fp.key)
+		fv := v.FieldByIndex(fp.field.Index)
+
+		if fp.iface {
+			sv := indirectStruct(fv)
+			if sv.Kind() != reflect.Struct {
+				continue
+			}
+
+			if _, ok := variants[fv.Type()]; ok {
+				name, ok := variantName(variants, fv.Type(), sv.Type())
+				if !ok {
+					return fmt.Errorf("marshal %s: %s is not a registered variant of %s", key, sv.Type(), fv.Type())
+				}
+
+				m[discriminatorKey(key, fp.field)] = strings.Replace(name, `$`, `$$`, -1)
+			}
+
+			inner := planPrefix{key: key, index: prefix.index1(fp.field.Index)}
+			if err := marshalFields(m, variants, planFor(sv.Type()), sv, inner); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if !fp.direct {
+			continue
+		}
+
+		value, err := marshalValue(fv)
+		if err != nil {
+			return wrapError(err, `marshal `+key)
+		}
+
+		m[key] = strings.Replace(value, `$`, `$$`, -1)
+	}
+
+	return nil
+}
+
+func marshalValue(v reflect.Value) (string, error) {
+	switch pt := v.Addr().Type(); {
+	case pt.Implements(_marshalerType):
+		return v.Addr().Interface().(Marshaler).MarshalConfig()
+	case pt.Implements(_textMarshalerType):
+		data, err := v.Addr().Interface().(encoding.TextMarshaler).MarshalText()
+		return string(data), err
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits()), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	default:
+		return ``, fmt.Errorf("cannot marshal value of type %s", v.Type())
+	}
+}
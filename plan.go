@@ -0,0 +1,173 @@
+package readconf
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// What Build needs to know about the fields of a struct type, so that it does
+// not have to walk the type and parse its tags every time. Plans are cached
+// per type and shared between goroutines, and must not be modified.
+type typePlan struct {
+	// The settable fields of the type and its nested structs, in the order
+	// they are walked. Interface fields are included, but not walked into,
+	// as the struct they hold is only known when building.
+	fields []fieldPlan
+
+	section       bool
+	defaultConfig bool
+}
+
+// The keys, index and namespace of a field are relative to the type of the
+// plan it belongs to.
+type fieldPlan struct {
+	field     reflect.StructField
+	key       string
+	namespace string
+
+	direct bool
+	iface  bool
+
+	section       bool
+	defaultConfig bool
+
+	// Set for fields that can be unmarshalled directly.
+	secret     bool
+	aliases    []string
+	requiredIf *condition
+	defaultTag *string
+
+	// Set for nested structs with an enabled_by tag.
+	enabledBy *condition
+}
+
+var _plans sync.Map
+
+func planFor(t reflect.Type) *typePlan {
+	if p, ok := _plans.Load(t); ok {
+		return p.(*typePlan)
+	}
+
+	p, _ := _plans.LoadOrStore(t, compilePlan(t))
+	return p.(*typePlan)
+}
+
+func compilePlan(t reflect.Type) *typePlan {
+	root := reflect.New(t).Elem()
+
+	p := &typePlan{
+		section:       !canUnmarshalDirectly(root),
+		defaultConfig: t.Implements(_defaultConfigType),
+	}
+
+	_ = walkStruct(
+		root.Addr().Interface(),
+		func(path []string, f reflect.StructField, v reflect.Value) (bool, error) {
+			if len(f.Index) == 0 {
+				return true, nil
+			}
+
+			if !v.CanSet() {
+				return false, nil
+			}
+
+			if tag, ok := f.Tag.Lookup(_configTag); ok && tag != `` {
+				if tag == `-` {
+					return false, nil
+				}
+
+				// Renamed in place, so that nested fields see the new name.
+				if !f.Anonymous {
+					path[len(path)-1] = normalizeKey(tag)
+				}
+			}
+
+			fp := fieldPlan{
+				field:     f,
+				key:       structKey(path),
+				namespace: fieldNamespace(root, f.Index),
+				direct:    canUnmarshalDirectly(v),
+				iface:     v.Kind() == reflect.Interface,
+			}
+
+			if !fp.iface {
+				fp.defaultConfig = v.Type().Implements(_defaultConfigType)
+			}
+
+			if v.Kind() == reflect.Struct {
+				fp.section = !f.Anonymous && !fp.direct
+
+				if tag, ok := f.Tag.Lookup(_enabledByTag); ok && tag != `` && !fp.direct {
+					c := parseCondition(tag)
+					fp.enabledBy = &c
+				}
+			}
+
+			if fp.direct {
+				fp.secret = isSecretField(f)
+				fp.aliases = fieldAliases(path, f)
+
+				if tag, ok := f.Tag.Lookup(_requiredIfTag); ok && tag != `` {
+					c := parseCondition(tag)
+					fp.requiredIf = &c
+				}
+
+				if tag, ok := f.Tag.Lookup(_defaultTag); ok {
+					fp.defaultTag = &tag
+				}
+			}
+
+			p.fields = append(p.fields, fp)
+			return true, nil
+		},
+	)
+
+	return p
+}
+
+// Where the fields of a plan are found within the target.
+type planPrefix struct {
+	key       string
+	index     []int
+	namespace string
+}
+
+func (p planPrefix) key1(key string) string {
+	return joinNonEmpty(_separator, p.key, key)
+}
+
+func (p planPrefix) index1(index []int) []int {
+	return copyAppendInt(p.index, index...)
+}
+
+func (p planPrefix) namespace1(namespace string) string {
+	return joinNonEmpty(`.`, p.namespace, namespace)
+}
+
+func joinNonEmpty(sep string, ss ...string) string {
+	out := make([]string, 0, len(ss))
+	for _, s := range ss {
+		if s != `` {
+			out = append(out, s)
+		}
+	}
+
+	return strings.Join(out, sep)
+}
+
+// Returns the values given by the DefaultConfig of v, prefixed by the given
+// key.
+func defaultConfigValues(v reflect.Value, key string) Map {
+	m1 := v.Interface().(DefaultConfig).DefaultConfig()
+
+	m2 := make(Map, len(m1))
+	for k, v := range m1 {
+		if key != "" {
+			k = key + _separator + k
+		}
+		m2[k] = v
+	}
+
+	return m2
+}
@@ -0,0 +1,134 @@
+package readconf
+
+import (
+	"fmt"
+	"sort"
+)
+
+type SourceKind int
+
+const (
+	SourceUnknown SourceKind = iota
+	SourceDefaultTag
+	SourceDefaultConfig
+	SourceFile
+	SourceData
+	SourceEnviron
+	SourceMap
+	SourceEnvironFallback
+)
+
+func (k SourceKind) String() string {
+	switch k {
+	case SourceDefaultTag:
+		return `default tag`
+	case SourceDefaultConfig:
+		return `DefaultConfig`
+	case SourceFile:
+		return `file`
+	case SourceData:
+		return `data`
+	case SourceEnviron:
+		return `environment`
+	case SourceMap:
+		return `map`
+	case SourceEnvironFallback:
+		return `environment fallback`
+	default:
+		return `unknown`
+	}
+}
+
+// Source identifies where a configuration value came from. Name is the file
+// name, environment prefix, struct field or type name, depending on the kind.
+// Line is only set for file and data sources.
+type Source struct {
+	Kind SourceKind
+	Name string
+	Line int
+}
+
+func (s Source) String() string {
+	switch {
+	case s.Name != `` && s.Line > 0:
+		return fmt.Sprintf(`%s %s:%d`, s.Kind, s.Name, s.Line)
+	case s.Name != ``:
+		return fmt.Sprintf(`%s %s`, s.Kind, s.Name)
+	case s.Line > 0:
+		return fmt.Sprintf(`%s line %d`, s.Kind, s.Line)
+	default:
+		return s.Kind.String()
+	}
+}
+
+// Origin is a value as supplied by a single source.
+type Origin struct {
+	Value  string
+	Source Source
+}
+
+// Provenance describes how the final value of a key was arrived at. Value is
+// the resolved value, Source is the source that supplied it, and Shadowed
+// lists the lower-priority values it replaced, most recent first. Secret is
+// set when the value is, or was interpolated from, a secret.
+type Provenance struct {
+	Key      string
+	Value    string
+	Source   Source
+	Shadowed []Origin
+	Secret   bool
+}
+
+// Records every value given for each key, in order of increasing priority.
+type origins map[string][]Origin
+
+func (o origins) add(key, value string, source Source) {
+	o[key] = append(o[key], Origin{Value: value, Source: source})
+}
+
+func (o origins) merge(other origins) {
+	for k, v := range other {
+		o[k] = append(o[k], v...)
+	}
+}
+
+func (o origins) provenance(values Map, secrets map[string]bool) map[string]Provenance {
+	out := make(map[string]Provenance, len(o))
+
+	for key, history := range o {
+		last := history[len(history)-1]
+
+		shadowed := make([]Origin, 0, len(history)-1)
+		for i := len(history) - 2; i >= 0; i-- {
+			shadowed = append(shadowed, history[i])
+		}
+
+		value, ok := values[key]
+		if !ok {
+			value = last.Value
+		}
+
+		out[key] = Provenance{
+			Key:      key,
+			Value:    value,
+			Source:   last.Source,
+			Shadowed: shadowed,
+			Secret:   secrets[key],
+		}
+	}
+
+	return out
+}
+
+func sortedProvenance(m map[string]Provenance) []Provenance {
+	out := make([]Provenance, 0, len(m))
+	for _, p := range m {
+		out = append(out, p)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Key < out[j].Key
+	})
+
+	return out
+}
@@ -0,0 +1,538 @@
+package readconf
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A piece of a parsed value: either literal text, or a reference.
+type token struct {
+	text string
+	ref  *reference
+}
+
+// A reference to another key within a value, in one of the forms:
+//
+//	$KEY, ${KEY}        the value of KEY
+//	${KEY:-word}        word if KEY is unset or empty
+//	${KEY-word}         word if KEY is unset
+//	${KEY:?message}     an error if KEY is unset or empty
+//	${KEY?message}      an error if KEY is unset
+//	${KEY:+word}        word if KEY is set and not empty
+//	${KEY+word}         word if KEY is set
+//
+// The word may itself contain references, which are only resolved if the
+// word is used. $$ stands for a literal dollar sign. Offset is the position of
+// the reference within the value.
+//
+// A reference of the form ${namespace:argument} is resolved by the resolver
+// registered for the namespace, and may be followed by one of the operators
+// :-, :? and :+. The argument may contain references.
+//
+// A braced reference may end in a pipeline of functions, as in
+// ${KEY:-word|lower|join:,}, which transform its value in turn. Neither words
+// nor arguments may contain a literal |.
+type reference struct {
+	key       string
+	namespace string
+	arg       []token
+	op        string
+	word      []token
+	funcs     []pipe
+	offset    int
+}
+
+// A function in the pipeline of a reference, with its argument.
+type pipe struct {
+	name string
+	arg  string
+}
+
+var (
+	_operators          = []string{`:-`, `:?`, `:+`, `-`, `?`, `+`}
+	_namespaceOperators = []string{`:-`, `:?`, `:+`}
+)
+
+// Parses a value into literal text and references.
+func parseValue(s string) ([]token, error) {
+	p := parser{s: s}
+
+	tokens, err := p.parseTokens(false, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+type parser struct {
+	s   string
+	pos int
+}
+
+// Parses tokens up to the end of the input or, if nested, up to the closing
+// brace or pipeline of the enclosing reference. The argument of a namespaced reference
+// also ends at an operator.
+func (p *parser) parseTokens(nested, arg bool) ([]token, error) {
+	var tokens []token
+	var text strings.Builder
+
+	flush := func() {
+		if text.Len() > 0 {
+			tokens = append(tokens, token{text: text.String()})
+			text.Reset()
+		}
+	}
+
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+
+		switch {
+		case (c == '}' || c == '|') && nested, arg && p.atOperator(_namespaceOperators):
+			flush()
+			return tokens, nil
+		case c == '$':
+			ref, literal, err := p.parseDollar()
+			if err != nil {
+				return nil, err
+			}
+
+			if ref == nil {
+				text.WriteString(literal)
+				continue
+			}
+
+			flush()
+			tokens = append(tokens, token{ref: ref})
+		default:
+			text.WriteByte(c)
+			p.pos++
+		}
+	}
+
+	flush()
+	return tokens, nil
+}
+
+// Parses what follows a dollar sign. Returns either a reference, or the
+// literal text it stands for.
+func (p *parser) parseDollar() (*reference, string, error) {
+	start := p.pos
+	p.pos++
+
+	if p.pos == len(p.s) {
+		return nil, `$`, nil
+	}
+
+	switch c := p.s[p.pos]; {
+	case c == '$':
+		p.pos++
+		return nil, `$`, nil
+	case c == '{':
+		p.pos++
+		ref, err := p.parseBraced(start)
+		return ref, ``, err
+	case isKeyStart(c):
+		return &reference{key: p.scanKey(), offset: start}, ``, nil
+	default:
+		return nil, `$`, nil
+	}
+}
+
+// Parses the remainder of a ${...} reference starting at the given offset.
+func (p *parser) parseBraced(start int) (*reference, error) {
+	ref := &reference{key: p.scanKey(), offset: start}
+
+	if ref.key == `` {
+		return nil, p.errorf(start, `expected key`)
+	}
+
+	operators := _operators
+
+	if p.pos < len(p.s) && p.s[p.pos] == ':' && !p.atOperator(_operators) {
+		p.pos++
+		ref.namespace, ref.key = ref.key, ``
+		operators = _namespaceOperators
+
+		arg, err := p.parseTokens(true, true)
+		if err != nil {
+			return nil, err
+		}
+
+		ref.arg = arg
+	}
+
+	for _, op := range operators {
+		if strings.HasPrefix(p.s[p.pos:], op) {
+			p.pos += len(op)
+			ref.op = op
+
+			word, err := p.parseTokens(true, false)
+			if err != nil {
+				return nil, err
+			}
+
+			ref.word = word
+			break
+		}
+	}
+
+	for p.pos < len(p.s) && p.s[p.pos] == '|' {
+		p.pos++
+
+		f := pipe{name: p.scanKey()}
+		if f.name == `` {
+			return nil, p.errorf(start, `expected function`)
+		}
+
+		if p.pos < len(p.s) && p.s[p.pos] == ':' {
+			p.pos++
+			end := strings.IndexAny(p.s[p.pos:], `|}`)
+			if end < 0 {
+				end = len(p.s) - p.pos
+			}
+
+			f.arg = p.s[p.pos : p.pos+end]
+			p.pos += end
+		}
+
+		ref.funcs = append(ref.funcs, f)
+	}
+
+	switch {
+	case p.pos == len(p.s):
+		return nil, p.errorf(start, `unterminated reference`)
+	case p.s[p.pos] != '}':
+		return nil, p.errorf(start, `unexpected %q`, p.s[p.pos])
+	}
+
+	p.pos++
+	return ref, nil
+}
+
+func (p *parser) atOperator(operators []string) bool {
+	for _, op := range operators {
+		if strings.HasPrefix(p.s[p.pos:], op) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *parser) scanKey() string {
+	start := p.pos
+	for p.pos < len(p.s) && isKeyChar(p.s[p.pos]) {
+		p.pos++
+	}
+
+	return p.s[start:p.pos]
+}
+
+func (p *parser) errorf(offset int, format string, args ...interface{}) error {
+	return &ReferenceError{Offset: offset, Message: fmt.Sprintf(format, args...)}
+}
+
+func isKeyStart(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func isKeyChar(c byte) bool {
+	return isKeyStart(c) || '0' <= c && c <= '9'
+}
+
+// Returns every key referenced by the given tokens, including those in words.
+func referencedKeys(tokens []token) []string {
+	var keys []string
+
+	for _, t := range tokens {
+		if t.ref != nil {
+			if t.ref.key != `` {
+				keys = append(keys, t.ref.key)
+			}
+
+			keys = append(keys, referencedKeys(t.ref.arg)...)
+			keys = append(keys, referencedKeys(t.ref.word)...)
+		}
+	}
+
+	return keys
+}
+
+type refState int
+
+const (
+	refResolved refState = iota
+	refMissing
+	refFailed
+)
+
+// Resolves references among the values of a Map, in place. Keys are only
+// resolved when asked for, and each at most once.
+type resolver struct {
+	m        Map
+	options  resolveOptions
+	resolved map[string]bool
+	failed   map[string]bool
+	errs     []error
+
+	// The keys being resolved, each referenced by the one before it, and
+	// their positions within the stack.
+	stack    []string
+	visiting map[string]int
+}
+
+// Configures the resolution of references beyond the values of the Map.
+type resolveOptions struct {
+	resolvers map[string]ResolverFunc
+	funcs     map[string]TransformFunc
+	fallback  func(key string) (string, bool)
+}
+
+func newResolver(m Map, options resolveOptions) *resolver {
+	return &resolver{
+		m:        m,
+		options:  options,
+		resolved: map[string]bool{},
+		failed:   map[string]bool{},
+		visiting: map[string]int{},
+	}
+}
+
+// Like resolveValueMap, but carries on past unresolvable keys. Returns every
+// error encountered, in the order of the keys they were found for, and the set
+// of keys left unresolved. A key whose reference could not be resolved only
+// produces an error where the problem originated.
+func resolveValues(m Map, options resolveOptions) ([]error, map[string]bool) {
+	sortedKeys := make([]string, 0, len(m))
+	for k := range m {
+		sortedKeys = append(sortedKeys, k)
+	}
+	sort.Strings(sortedKeys)
+
+	return resolveKeys(m, sortedKeys, options)
+}
+
+// Like resolveValues, but only resolves the given keys and those they
+// reference, in order. Other values are left as they are, even if they could
+// not be resolved.
+func resolveKeys(m Map, keys []string, options resolveOptions) ([]error, map[string]bool) {
+	r := newResolver(m, options)
+
+	for _, k := range keys {
+		r.resolve(k)
+	}
+
+	return r.errs, r.failed
+}
+
+func (r *resolver) resolve(key string) refState {
+	if i, ok := r.visiting[key]; ok {
+		cycle := []string{key}
+		for j := len(r.stack) - 1; j >= i; j-- {
+			cycle = append(cycle, r.stack[j])
+		}
+
+		r.errs = append(r.errs, &ReferenceError{
+			Key:   r.stack[len(r.stack)-1],
+			Ref:   key,
+			Cycle: cycle,
+		})
+		return refFailed
+	}
+
+	switch {
+	case r.failed[key]:
+		return refFailed
+	case r.resolved[key]:
+		return refResolved
+	}
+
+	value, ok := r.m[key]
+	if !ok {
+		return refMissing
+	}
+
+	tokens, err := parseValue(value)
+	if err != nil {
+		err.(*ReferenceError).Key = key
+		r.errs = append(r.errs, err)
+		r.failed[key] = true
+		return refFailed
+	}
+
+	r.visiting[key] = len(r.stack)
+	r.stack = append(r.stack, key)
+
+	value, ok = r.expand(key, tokens)
+
+	r.stack = r.stack[:len(r.stack)-1]
+	delete(r.visiting, key)
+
+	if !ok {
+		r.failed[key] = true
+		return refFailed
+	}
+
+	r.m[key] = value
+	r.resolved[key] = true
+	return refResolved
+}
+
+// Returns the given tokens of the value of key with their references
+// resolved, or false if any reference failed.
+func (r *resolver) expand(key string, tokens []token) (string, bool) {
+	var out strings.Builder
+
+	for _, t := range tokens {
+		if t.ref == nil {
+			out.WriteString(t.text)
+			continue
+		}
+
+		ref := t.ref
+
+		name, value, set, ok := r.lookup(key, ref)
+		if !ok {
+			return ``, false
+		}
+
+		empty := !set || value == ``
+
+		useWord, fail := false, false
+
+		switch ref.op {
+		case `:-`:
+			useWord = empty
+		case `-`:
+			useWord = !set
+		case `:?`:
+			fail = empty
+		case `?`, ``:
+			fail = !set
+		case `:+`:
+			useWord, value = !empty, ``
+		case `+`:
+			useWord, value = set, ``
+		}
+
+		if useWord || fail {
+			word, ok := r.expand(key, ref.word)
+			if !ok {
+				return ``, false
+			}
+
+			value = word
+		}
+
+		if fail {
+			r.errs = append(r.errs, &ReferenceError{
+				Key:     key,
+				Ref:     name,
+				Offset:  ref.offset,
+				Message: value,
+			})
+			return ``, false
+		}
+
+		value, ok = r.transform(key, name, ref, value)
+		if !ok {
+			return ``, false
+		}
+
+		out.WriteString(value)
+	}
+
+	return out.String(), true
+}
+
+// Applies the pipeline of a reference to its value, or returns false if any of
+// its functions failed.
+func (r *resolver) transform(key, name string, ref *reference, value string) (string, bool) {
+	for _, p := range ref.funcs {
+		f, ok := r.options.funcs[p.name]
+		if !ok {
+			f, ok = _builtinFuncs[p.name]
+		}
+
+		var err error
+		if !ok {
+			err = fmt.Errorf(`unknown function %s`, p.name)
+		} else if value, err = f(value, p.arg); err != nil {
+			err = fmt.Errorf(`%s: %s`, p.name, err)
+		}
+
+		if err != nil {
+			r.errs = append(r.errs, &ReferenceError{
+				Key:     key,
+				Ref:     name,
+				Offset:  ref.offset,
+				Message: err.Error(),
+			})
+			return ``, false
+		}
+	}
+
+	return value, true
+}
+
+// Looks up the value of a reference in the value of key. Returns the name of
+// what was referenced, its value and whether it is set, or false if resolution
+// failed.
+func (r *resolver) lookup(key string, ref *reference) (string, string, bool, bool) {
+	if ref.namespace == `` {
+		state := r.resolve(ref.key)
+		if state == refMissing && r.options.fallback != nil {
+			if value, ok := r.options.fallback(ref.key); ok {
+				r.m[ref.key] = value
+				r.resolved[ref.key] = true
+				state = refResolved
+			}
+		}
+
+		if state == refFailed {
+			return ``, ``, false, false
+		}
+
+		return ref.key, r.m[ref.key], state == refResolved, true
+	}
+
+	arg, ok := r.expand(key, ref.arg)
+	if !ok {
+		return ``, ``, false, false
+	}
+
+	name := ref.namespace + `:` + arg
+
+	f, ok := r.options.resolvers[ref.namespace]
+	if !ok {
+		f, ok = _builtinResolvers[ref.namespace]
+	}
+
+	if !ok {
+		r.errs = append(r.errs, &ReferenceError{
+			Key:     key,
+			Ref:     name,
+			Offset:  ref.offset,
+			Message: fmt.Sprintf(`unknown namespace %s`, ref.namespace),
+		})
+		return ``, ``, false, false
+	}
+
+	value, err := f(arg)
+	switch {
+	case err == ErrNotFound:
+		return name, ``, false, true
+	case err != nil:
+		r.errs = append(r.errs, &ReferenceError{
+			Key:     key,
+			Ref:     name,
+			Offset:  ref.offset,
+			Message: err.Error(),
+		})
+		return ``, ``, false, false
+	}
+
+	return name, value, true, true
+}
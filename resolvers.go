@@ -0,0 +1,92 @@
+package readconf
+
+import (
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// ResolverFunc resolves the argument of a namespaced reference, such as HOME
+// in ${env:HOME}. It returns ErrNotFound if there is no value for the
+// argument, in which case the :- and :+ operators apply, and resolution fails
+// otherwise.
+type ResolverFunc func(arg string) (string, error)
+
+var ErrNotFound = errors.New(`not found`)
+
+var _builtinResolvers = map[string]ResolverFunc{
+	`env`:    resolveEnv,
+	`file`:   resolveFile,
+	`base64`: resolveBase64,
+}
+
+// RegisterResolver registers a resolver for references of the form
+// ${namespace:argument}. The built-in namespaces are env, which looks up an
+// environment variable, file, which reads a file less a trailing newline, and
+// base64, which decodes its argument. They may be overridden.
+func (b *Builder) RegisterResolver(namespace string, f ResolverFunc) *Builder {
+	if b.hasError() {
+		return b
+	}
+
+	if b.resolvers == nil {
+		b.resolvers = map[string]ResolverFunc{}
+	}
+
+	b.resolvers[namespace] = f
+	return b
+}
+
+// WithEnvironFallback makes references to keys that have no value resolve to
+// the value given by lookup instead, or by os.LookupEnv if lookup is nil. Such
+// values are used as they are, and are reported by Explain with the
+// environment fallback source.
+func (b *Builder) WithEnvironFallback(lookup func(key string) (string, bool)) *Builder {
+	if b.hasError() {
+		return b
+	}
+
+	if lookup == nil {
+		lookup = os.LookupEnv
+	}
+
+	b.fallback = lookup
+	return b
+}
+
+func (b *Builder) resolveOptions() resolveOptions {
+	return resolveOptions{resolvers: b.resolvers, funcs: b.funcs, fallback: b.fallback}
+}
+
+func resolveEnv(name string) (string, error) {
+	if v, ok := os.LookupEnv(name); ok {
+		return v, nil
+	}
+
+	return ``, ErrNotFound
+}
+
+func resolveFile(filename string) (string, error) {
+	data, err := ioutil.ReadFile(filename)
+	switch {
+	case os.IsNotExist(err):
+		return ``, ErrNotFound
+	case err != nil:
+		return ``, err
+	}
+
+	s := strings.TrimSuffix(string(data), "\n")
+	s = strings.TrimSuffix(s, "\r")
+	return s, nil
+}
+
+func resolveBase64(s string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return ``, err
+	}
+
+	return string(data), nil
+}
@@ -0,0 +1,205 @@
+package readconf
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const _schemaDialect = `https://json-schema.org/draft/2020-12/schema`
+
+// JSONSchema returns a JSON Schema for an object holding the configuration
+// keys expected by the given struct, or pointer to a struct, as properties.
+// The schema includes the types, defaults, descriptions and allowed values of
+// keys, lists the keys that must be set as required, and translates the min,
+// max, len, gt, gte, lt, lte, oneof, required, url, uri, email, hostname, ipv4
+// and ipv6 validations into schema keywords. Defaults of secrets are left out.
+func JSONSchema(target interface{}) ([]byte, error) {
+	return jsonSchema(target, false)
+}
+
+// NestedJSONSchema is like JSONSchema, but nests the keys of structs within
+// objects of their own, as in {"DATABASE": {"HOST": ...}}, instead of listing
+// them as DATABASE__HOST.
+func NestedJSONSchema(target interface{}) ([]byte, error) {
+	return jsonSchema(target, true)
+}
+
+type schema map[string]interface{}
+
+func jsonSchema(target interface{}, nested bool) ([]byte, error) {
+	fields, types, err := describe(target, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	root := schema{`$schema`: _schemaDialect}
+	objectSchema(root)
+
+	for i, f := range fields {
+		path := []string{f.Key}
+		if nested {
+			path = strings.Split(f.Key, _separator)
+		}
+
+		parent := root
+		for _, name := range path[:len(path)-1] {
+			properties := parent[`properties`].(schema)
+
+			child, ok := properties[name].(schema)
+			if !ok {
+				child = objectSchema(schema{})
+				properties[name] = child
+			}
+
+			if f.Required {
+				addRequired(parent, name)
+			}
+
+			parent = objectSchema(child)
+		}
+
+		name := path[len(path)-1]
+		properties := parent[`properties`].(schema)
+
+		property, ok := properties[name].(schema)
+		if !ok {
+			property = schema{}
+			properties[name] = property
+		}
+
+		fieldSchema(property, f, types[i])
+
+		if f.Required {
+			addRequired(parent, name)
+		}
+	}
+
+	return json.MarshalIndent(root, ``, `  `)
+}
+
+// Makes the given schema describe an object, keeping any properties it has.
+func objectSchema(s schema) schema {
+	s[`type`] = `object`
+	if _, ok := s[`properties`]; !ok {
+		s[`properties`] = schema{}
+	}
+
+	return s
+}
+
+func addRequired(s schema, name string) {
+	required, _ := s[`required`].([]string)
+	for _, r := range required {
+		if r == name {
+			return
+		}
+	}
+
+	s[`required`] = append(required, name)
+}
+
+func fieldSchema(s schema, f FieldInfo, t reflect.Type) {
+	typ := schemaType(t)
+	if _, ok := s[`type`]; !ok {
+		s[`type`] = typ
+	}
+
+	if f.Description != `` {
+		s[`description`] = f.Description
+	}
+
+	if f.Secret {
+		s[`writeOnly`] = true
+	} else if f.HasDefault {
+		s[`default`] = schemaValue(f.Default, typ)
+	}
+
+	length := typ == `string`
+
+	for _, rule := range strings.Split(f.Validate, `,`) {
+		kvp := strings.SplitN(rule, `=`, 2)
+		tag, param := kvp[0], ``
+		if len(kvp) == 2 {
+			param = kvp[1]
+		}
+
+		n, err := strconv.ParseFloat(param, 64)
+		numeric := err == nil
+
+		switch {
+		case tag == `required` && length:
+			if _, ok := s[`minLength`]; !ok {
+				s[`minLength`] = 1
+			}
+		case (tag == `min` || tag == `gte`) && numeric && length:
+			s[`minLength`] = n
+		case (tag == `max` || tag == `lte`) && numeric && length:
+			s[`maxLength`] = n
+		case tag == `len` && numeric && length:
+			s[`minLength`], s[`maxLength`] = n, n
+		case (tag == `min` || tag == `gte`) && numeric:
+			s[`minimum`] = n
+		case (tag == `max` || tag == `lte`) && numeric:
+			s[`maximum`] = n
+		case tag == `gt` && numeric && !length:
+			s[`exclusiveMinimum`] = n
+		case tag == `lt` && numeric && !length:
+			s[`exclusiveMaximum`] = n
+		case tag == `len` && numeric:
+			s[`const`] = n
+		case tag == `oneof`:
+			enum := make([]interface{}, 0, len(f.Enum))
+			for _, v := range f.Enum {
+				enum = append(enum, schemaValue(v, typ))
+			}
+			s[`enum`] = enum
+		case tag == `url` || tag == `uri`:
+			s[`format`] = `uri`
+		case tag == `email` || tag == `hostname` || tag == `ipv4` || tag == `ipv6`:
+			s[`format`] = tag
+		}
+	}
+}
+
+// Returns the JSON Schema type of values of the given type.
+func schemaType(t reflect.Type) string {
+	pt := reflect.PtrTo(t)
+	if pt.Implements(_unmarshalerType) || pt.Implements(_textUnmarshalerType) {
+		return `string`
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return `boolean`
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return `integer`
+	case reflect.Float32, reflect.Float64:
+		return `number`
+	default:
+		return `string`
+	}
+}
+
+// Returns the given value as a value of the given JSON Schema type, or as a
+// string if it isn't one.
+func schemaValue(value, typ string) interface{} {
+	switch typ {
+	case `boolean`:
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	case `integer`:
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return n
+		}
+	case `number`:
+		if n, err := strconv.ParseFloat(value, 64); err == nil {
+			return n
+		}
+	}
+
+	return value
+}
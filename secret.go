@@ -0,0 +1,87 @@
+package readconf
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Secret is a string configuration value that is redacted whenever it is
+// formatted or marshalled. Use Reveal to access the underlying value.
+type Secret string
+
+func (s *Secret) UnmarshalConfig(v string) error {
+	*s = Secret(v)
+	return nil
+}
+
+// MarshalConfig reveals the value, so that Marshal can pass it on.
+func (s Secret) MarshalConfig() (string, error) {
+	return string(s), nil
+}
+
+func (s Secret) Reveal() string {
+	return string(s)
+}
+
+func (Secret) String() string {
+	return _redacted
+}
+
+func (Secret) GoString() string {
+	return strconv.Quote(_redacted)
+}
+
+func (Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(_redacted)
+}
+
+func (Secret) Format(f fmt.State, verb rune) {
+	formatRedacted(f, verb)
+}
+
+func (Secret) secret() {}
+
+// SecretBytes is like Secret, but backed by a byte slice.
+type SecretBytes []byte
+
+func (s *SecretBytes) UnmarshalConfig(v string) error {
+	*s = SecretBytes(v)
+	return nil
+}
+
+func (s SecretBytes) MarshalConfig() (string, error) {
+	return string(s), nil
+}
+
+func (s SecretBytes) Reveal() []byte {
+	return []byte(s)
+}
+
+func (SecretBytes) String() string {
+	return _redacted
+}
+
+func (SecretBytes) GoString() string {
+	return strconv.Quote(_redacted)
+}
+
+func (SecretBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(_redacted)
+}
+
+func (SecretBytes) Format(f fmt.State, verb rune) {
+	formatRedacted(f, verb)
+}
+
+func (SecretBytes) secret() {}
+
+func formatRedacted(f fmt.State, verb rune) {
+	switch {
+	case verb == 'q', verb == 'v' && f.Flag('#'):
+		_, _ = io.WriteString(f, strconv.Quote(_redacted))
+	default:
+		_, _ = io.WriteString(f, _redacted)
+	}
+}
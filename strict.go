@@ -0,0 +1,88 @@
+package readconf
+
+import (
+	"sort"
+)
+
+// WithStrict makes Build fail when a file, data or prefixed environment
+// source sets a key that does not correspond to any field of the target.
+// Environment sources merged without a prefix are never checked, since they
+// contain variables that are not meant for the configuration. The unknown keys
+// are reported as an UnknownKeysError.
+func (b *Builder) WithStrict(strict bool) *Builder {
+	if b.hasError() {
+		return b
+	}
+
+	b.strict = strict
+	return b
+}
+
+// Returns the keys set by strict sources that are not known, or nil if there
+// are none.
+func (b *Builder) unknownKeysError(known map[string]bool) *UnknownKeysError {
+	unknown := []string{}
+
+	for key, history := range b.origins {
+		key = normalizeKey(key)
+		if known[key] {
+			continue
+		}
+
+		for _, origin := range history {
+			if isStrictSource(origin.Source) {
+				unknown = append(unknown, key)
+				break
+			}
+		}
+	}
+
+	if len(unknown) == 0 {
+		return nil
+	}
+
+	sort.Strings(unknown)
+
+	suggestions := map[string]string{}
+	for _, key := range unknown {
+		if suggestion := suggestKey(key, known); suggestion != `` {
+			suggestions[key] = suggestion
+		}
+	}
+
+	return &UnknownKeysError{Keys: unknown, Suggestions: suggestions}
+}
+
+func isStrictSource(s Source) bool {
+	switch s.Kind {
+	case SourceFile, SourceData:
+		return true
+	case SourceEnviron:
+		return s.Name != ``
+	default:
+		return false
+	}
+}
+
+// Returns the known key closest to the given key, or an empty string if none
+// of them is close enough to be a plausible typo.
+func suggestKey(key string, known map[string]bool) string {
+	best, bestDistance := ``, len(key)/3
+	if bestDistance < 1 {
+		bestDistance = 1
+	}
+
+	candidates := make([]string, 0, len(known))
+	for k := range known {
+		candidates = append(candidates, k)
+	}
+	sort.Strings(candidates)
+
+	for _, candidate := range candidates {
+		if d := editDistance(key, candidate); d <= bestDistance && (best == `` || d < bestDistance) {
+			best, bestDistance = candidate, d
+		}
+	}
+
+	return best
+}
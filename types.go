@@ -13,10 +13,35 @@ type Unmarshaler interface {
 	UnmarshalConfig(s string) error
 }
 
+// Marshaler is the counterpart of Unmarshaler, used by Marshal.
+type Marshaler interface {
+	MarshalConfig() (string, error)
+}
+
+// Finalizer is implemented by configuration structs that need to compute
+// derived fields once their values have been unmarshalled.
+type Finalizer interface {
+	Finalize() error
+}
+
+// Validatable is implemented by configuration structs that validate
+// themselves, such as to enforce rules that span several fields.
+type Validatable interface {
+	Validate() error
+}
+
+// Implemented by Secret and SecretBytes.
+type secretValue interface {
+	secret()
+}
+
 var (
 	_defaultConfigType   = reflect.TypeOf(new(DefaultConfig)).Elem()
+	_secretValueType     = reflect.TypeOf(new(secretValue)).Elem()
 	_unmarshalerType     = reflect.TypeOf(new(Unmarshaler)).Elem()
 	_textUnmarshalerType = reflect.TypeOf(new(encoding.TextUnmarshaler)).Elem()
+	_marshalerType       = reflect.TypeOf(new(Marshaler)).Elem()
+	_textMarshalerType   = reflect.TypeOf(new(encoding.TextMarshaler)).Elem()
 )
 
 type InspectorStage int
@@ -4,51 +4,14 @@ import (
 	"fmt"
 	"reflect"
 	"regexp"
-	"sort"
 	"strings"
 )
 
 var (
-	_capital1  = regexp.MustCompile(`[A-Z][a-z]+`)
-	_capital2  = regexp.MustCompile(`[A-Z][A-Z]+`)
-	_reference = regexp.MustCompile(`\$\{([^}]+)(?:\:-[^}]*)?\}`)
+	_capital1 = regexp.MustCompile(`[A-Z][a-z]+`)
+	_capital2 = regexp.MustCompile(`[A-Z][A-Z]+`)
 )
 
-func parseReferences(v string) (refs []string, defaults map[string]string) {
-	ss := _reference.FindAllStringSubmatch(v, -1)
-
-	refSet := make(map[string]struct{}, len(ss))
-	defaults = make(map[string]string, len(ss))
-
-	for i := range ss {
-		sss := strings.SplitN(ss[i][1], ":-", 2)
-		refSet[sss[0]] = struct{}{}
-		if len(sss) == 2 {
-			defaults[sss[0]] = sss[1]
-		}
-	}
-
-	refs = make([]string, 0, len(refSet))
-	for k := range refSet {
-		refs = append(refs, k)
-	}
-
-	return
-}
-
-// ignores defaults
-func replaceReferences(s string, data Map) string {
-	return _reference.ReplaceAllStringFunc(s, func(s string) string {
-		ss := strings.SplitN(s[2:len(s)-1], ":-", 2)
-		v, ok := data[ss[0]]
-		if !ok {
-			return s
-		}
-
-		return v
-	})
-}
-
 func transformStructKey(v string) string {
 	v = _capital2.ReplaceAllString(v, `_$0`)
 	v = _capital1.ReplaceAllStringFunc(v, func(s string) string {
@@ -71,6 +34,13 @@ func copyAppend(in []string, ss ...string) []string {
 	return out
 }
 
+func copyAppendInt(in []int, is ...int) []int {
+	out := make([]int, 0, len(in)+len(is))
+	out = append(out, in...)
+	out = append(out, is...)
+	return out
+}
+
 func validateIsPointerToStruct(v interface{}) error {
 	switch {
 	case v == nil:
@@ -85,77 +55,23 @@ func validateIsPointerToStruct(v interface{}) error {
 }
 
 // Resolves references among values in the given Map. A reference is a substring
-// of the form ${other_var} or ${other_var:-default}. To "resolve" is to replace
-// references with their values from the given map.
+// of the form ${other_var} or ${other_var:-default}, or any of the other forms
+// described by reference. To "resolve" is to replace references with their
+// values from the given map.
 //
 // A value in the map is available to be used for resolution if it no longer
 // contains any references itself.
 func resolveValueMap(m Map) error {
-	var resolve func(key string, cycle []string) (bool, error)
-
-	resolve = func(key string, cycle []string) (bool, error) {
-		for _, ref := range cycle {
-			if ref == key {
-				return false, fmt.Errorf(
-					`cyclic reference: %s, %s`,
-					key, strings.Join(cycle, `, `))
-			}
-		}
-
-		cycle = append([]string{key}, cycle...)
-
-		value, ok := m[key]
-		if !ok {
-			return false, nil
-		}
-
-		valueRefs, valueDefs := parseReferences(value)
-		resolved := make(Map, len(valueRefs))
-
-		for _, ref := range valueRefs {
-			ok, err := resolve(ref, cycle)
-			switch {
-			case err != nil:
-				return false, err
-			case !ok:
-				v, ok := valueDefs[ref]
-				if !ok {
-					return false, fmt.Errorf(`key %s referenced by %s not found`, ref, key)
-				}
-
-				resolved[ref] = v
-			default:
-				resolved[ref] = m[ref]
-			}
-		}
-
-		if len(resolved) != len(valueRefs) {
-			panic(`expected to have resolved all references in ` + key)
-		}
-
-		m[key] = replaceReferences(m[key], resolved)
-		return true, nil
-	}
-
-	sortedKeys := make([]string, 0, len(m))
-	for k := range m {
-		sortedKeys = append(sortedKeys, k)
-	}
-	sort.Strings(sortedKeys)
-
-	for _, k := range sortedKeys {
-		ok, err := resolve(k, nil)
-		switch {
-		case err != nil:
-			return err
-		case !ok:
-			panic(`unexpected !ok for ` + k)
-		}
+	if errs, _ := resolveValues(m, resolveOptions{}); len(errs) > 0 {
+		return errs[0]
 	}
 
 	return nil
 }
 
+// The index of each field given to walker is its full index sequence relative
+// to x, as used by fieldByIndex. Interface fields holding a pointer to a struct
+// are walked into as if they were the struct.
 func walkStruct(
 	x interface{},
 	walker func(path []string, f reflect.StructField, v reflect.Value) (bool, error),
@@ -176,6 +92,7 @@ func walkStruct(
 
 		for i := 0; i < vt.NumField(); i++ {
 			fv, ft := vv.Field(i), vt.Field(i)
+			ft.Index = copyAppendInt(f.Index, i)
 
 			path := prefix
 			if !ft.Anonymous {
@@ -192,6 +109,10 @@ func walkStruct(
 				if err := walk(fv, ft, path); err != nil {
 					return err
 				}
+			} else if sv := indirectStruct(fv); sv.Kind() == reflect.Struct {
+				if err := walk(sv, ft, path); err != nil {
+					return err
+				}
 			}
 		}
 
@@ -223,7 +144,7 @@ func canUnmarshalDirectly(v reflect.Value) bool {
 	t := v.Type()
 
 	switch {
-	case t.Implements(_unmarshalerType):
+	case reflect.PtrTo(t).Implements(_unmarshalerType):
 		return true
 	case t.Kind() == reflect.Struct:
 		return false
@@ -243,3 +164,77 @@ func structKey(path []string) string {
 
 	return key
 }
+
+// Returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			curr[j] = minInt(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+
+		prev, curr = curr, prev
+	}
+
+	return prev[len(b)]
+}
+
+func minInt(x int, xs ...int) int {
+	for _, y := range xs {
+		if y < x {
+			x = y
+		}
+	}
+
+	return x
+}
+
+// Returns the names of the fields along the given index sequence, including
+// those of embedded structs, joined by dots.
+func fieldNamespace(v reflect.Value, index []int) string {
+	names := make([]string, len(index))
+	for i, j := range index {
+		v = indirectStruct(v)
+		names[i] = v.Type().Field(j).Name
+		v = v.Field(j)
+	}
+
+	return strings.Join(names, `.`)
+}
+
+// Like reflect.Value.FieldByIndex, but also steps into structs referenced by
+// interface fields.
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for _, i := range index {
+		v = indirectStruct(v).Field(i)
+	}
+
+	return v
+}
+
+// Returns the struct pointed to by the value of an interface, or the given
+// value if it is not such an interface.
+func indirectStruct(v reflect.Value) reflect.Value {
+	if v.Kind() != reflect.Interface || v.IsNil() {
+		return v
+	}
+
+	if e := v.Elem(); e.Kind() == reflect.Ptr && !e.IsNil() && e.Elem().Kind() == reflect.Struct {
+		return e.Elem()
+	}
+
+	return v
+}
@@ -0,0 +1,161 @@
+package readconf
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+)
+
+// WithTranslator makes validation messages use the translations registered
+// with the given translator, instead of the built-in English messages.
+func (b *Builder) WithTranslator(trans ut.Translator) *Builder {
+	if b.hasError() {
+		return b
+	}
+
+	b.translator = trans
+	return b
+}
+
+// Converts errors returned by the validator into a ValidationError. The
+// namespaces map the struct namespace of each field, less the name of the
+// root struct, to its configuration key.
+func (b *Builder) newValidationError(
+	err error,
+	root string,
+	namespaces map[string]string,
+	secretKeys map[string]bool,
+) error {
+	errs, ok := err.(validator.ValidationErrors)
+	if !ok {
+		return err
+	}
+
+	verr := &ValidationError{Fields: make([]FieldError, 0, len(errs))}
+
+	for _, err := range errs {
+		ns := err.StructNamespace()
+		if root != `` {
+			ns = strings.TrimPrefix(ns, root+`.`)
+		}
+
+		key, ok := namespaces[ns]
+		if !ok {
+			key = normalizeKey(stringReplaceAll(ns, `.`, _separator))
+		}
+
+		secret := secretKeys[key]
+
+		fe := FieldError{
+			Key:     key,
+			Tag:     err.Tag(),
+			Param:   err.Param(),
+			Value:   err.Value(),
+			Message: b.fieldErrorMessage(err, key, secret),
+		}
+
+		if secret {
+			fe.Value = _redacted
+		}
+
+		verr.Fields = append(verr.Fields, fe)
+	}
+
+	sort.Slice(verr.Fields, func(i, j int) bool {
+		return verr.Fields[i].Key < verr.Fields[j].Key
+	})
+
+	return verr
+}
+
+func (b *Builder) fieldErrorMessage(err validator.FieldError, key string, secret bool) string {
+	if b.translator != nil {
+		msg := err.Translate(b.translator)
+
+		if strings.HasPrefix(msg, err.Field()+` `) {
+			return strings.TrimPrefix(msg, err.Field()+` `)
+		}
+
+		return stringReplaceAll(msg, err.Field(), key)
+	}
+
+	msg, got := describeFieldError(err)
+	if got != `` && !secret {
+		msg += ` (got ` + got + `)`
+	}
+
+	return msg
+}
+
+// Returns an English description of the failed validation, and a description
+// of the offending value, if one is relevant.
+func describeFieldError(err validator.FieldError) (msg, got string) {
+	param := err.Param()
+	kind := err.Kind()
+
+	var unit string
+	var length bool
+
+	switch kind {
+	case reflect.String:
+		unit, length = ` characters`, true
+	case reflect.Slice, reflect.Map, reflect.Array:
+		unit, length = ` items`, true
+	}
+
+	if length {
+		got = fmt.Sprint(reflect.ValueOf(err.Value()).Len())
+	} else {
+		got = fmt.Sprint(err.Value())
+	}
+
+	switch err.Tag() {
+	case `required`:
+		return `is required`, ``
+	case `min`, `gte`:
+		return `must be at least ` + param + unit, got
+	case `max`, `lte`:
+		return `must be at most ` + param + unit, got
+	case `gt`:
+		return `must be more than ` + param + unit, got
+	case `lt`:
+		return `must be less than ` + param + unit, got
+	case `len`:
+		return `must be exactly ` + param + unit, got
+	}
+
+	if kind == reflect.String {
+		got = fmt.Sprintf(`%q`, err.Value())
+	}
+
+	switch err.Tag() {
+	case `eq`:
+		return `must be equal to ` + param, got
+	case `ne`:
+		return `must not be equal to ` + param, got
+	case `oneof`:
+		return `must be one of ` + strings.Join(strings.Fields(param), `, `), got
+	case `email`:
+		return `must be a valid email address`, got
+	case `url`:
+		return `must be a valid URL`, got
+	case `uri`:
+		return `must be a valid URI`, got
+	case `hostname`, `hostname_rfc1123`:
+		return `must be a valid hostname`, got
+	case `ip`, `ipv4`, `ipv6`:
+		return `must be a valid IP address`, got
+	case `numeric`, `number`:
+		return `must be numeric`, got
+	}
+
+	if param != `` {
+		return fmt.Sprintf(`failed validation %s=%s`, err.Tag(), param), got
+	}
+
+	return fmt.Sprintf(`failed validation %s`, err.Tag()), got
+}
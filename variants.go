@@ -0,0 +1,163 @@
+package readconf
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// RegisterVariant registers a struct type to be used for fields of the given
+// interface type when their discriminator key has the given value. The
+// interface is given as a nil pointer to it, and the variant as a value or
+// pointer of the struct type, such as:
+//
+//	b.RegisterVariant((*Storage)(nil), "s3", S3Storage{})
+//
+// A pointer to the struct type must implement the interface. The
+// discriminator key of a field is its own key followed by TYPE, unless
+// overridden by its discriminator tag. The default tag of the field sets the
+// default value of the discriminator, which a DefaultConfig method of the
+// enclosing struct overrides, as for any other key. Fields within a struct disabled by its
+// enabled_by tag need no discriminator.
+func (b *Builder) RegisterVariant(iface interface{}, value string, variant interface{}) *Builder {
+	if b.hasError() {
+		return b
+	}
+
+	it := reflect.TypeOf(iface)
+	if it == nil || it.Kind() != reflect.Ptr || it.Elem().Kind() != reflect.Interface {
+		b.err = fmt.Errorf("expected a pointer to an interface")
+		return b
+	}
+	it = it.Elem()
+
+	vt := reflect.TypeOf(variant)
+	if vt != nil && vt.Kind() == reflect.Ptr {
+		vt = vt.Elem()
+	}
+
+	switch {
+	case vt == nil || vt.Kind() != reflect.Struct:
+		b.err = fmt.Errorf("expected variant to be a struct")
+		return b
+	case !reflect.PtrTo(vt).Implements(it):
+		b.err = fmt.Errorf("variant %s does not implement %s", reflect.PtrTo(vt), it)
+		return b
+	}
+
+	if b.variants == nil {
+		b.variants = map[reflect.Type]map[string]reflect.Type{}
+	}
+
+	if b.variants[it] == nil {
+		b.variants[it] = map[string]reflect.Type{}
+	}
+
+	b.variants[it][value] = vt
+	return b
+}
+
+// An error selecting the variant of the interface field at the given index.
+type variantError struct {
+	index []int
+	err   error
+}
+
+// Sets the interface field v to a new instance of the variant selected by its
+// discriminator key, which is returned. The discriminator is looked up in the
+// given defaults, overridden by the DefaultConfig values, the values given to
+// the builder and, if it is not set itself, its aliases. Its default tag is
+// added to the defaults. The error is a MissingKeysError or UnmarshalError for
+// the discriminator.
+func (b *Builder) selectVariant(
+	key string,
+	f reflect.StructField,
+	v reflect.Value,
+	defaults Map,
+	defaultOrigins origins,
+	configDefaults Map,
+) (string, error) {
+	variants := b.variants[v.Type()]
+	discriminator := discriminatorKey(key, f)
+
+	if tag, ok := f.Tag.Lookup(_defaultTag); ok {
+		defaults.Set(discriminator, tag)
+		defaultOrigins.add(discriminator, tag, Source{Kind: SourceDefaultTag, Name: f.Name})
+	}
+
+	m := make(Map, len(defaults)+len(configDefaults)+len(b.values))
+	m.Merge(defaults)
+	m.Merge(configDefaults)
+	m.Merge(b.values)
+
+	if _, ok := b.values.Lookup(discriminator); !ok {
+		for _, alias := range b.aliases[discriminator] {
+			if value, ok := b.values.Lookup(alias); ok {
+				m.Set(discriminator, value)
+				break
+			}
+		}
+	}
+
+	value, ok := resolvedLookup(m, b.resolveOptions())(discriminator)
+	if !ok {
+		return discriminator, &MissingKeysError{Keys: []string{discriminator}}
+	}
+
+	vt, ok := variants[value]
+	if !ok {
+		return discriminator, &UnmarshalError{
+			Key: discriminator,
+			Err: fmt.Errorf(`unknown variant "%s", expected one of: %s`, value, strings.Join(variantNames(variants), `, `)),
+		}
+	}
+
+	v.Set(reflect.New(vt))
+	return discriminator, nil
+}
+
+// Returns the discriminator key of the interface field f with the given key.
+func discriminatorKey(key string, f reflect.StructField) string {
+	discriminator := _discriminator
+	if tag, ok := f.Tag.Lookup(_discriminatorTag); ok && tag != `` {
+		discriminator = normalizeKey(tag)
+	}
+
+	if key != `` {
+		discriminator = key + _separator + discriminator
+	}
+
+	return discriminator
+}
+
+// Returns the values of a discriminator that select the given variants, in
+// sort order.
+func variantNames(variants map[string]reflect.Type) []string {
+	names := make([]string, 0, len(variants))
+	for name := range variants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Returns the value of the discriminator that selects the given variant of
+// the interface type. If several values select it, the first in sort order is
+// returned.
+func variantName(variants map[reflect.Type]map[string]reflect.Type, it, vt reflect.Type) (string, bool) {
+	names := make([]string, 0, len(variants[it]))
+	for name, t := range variants[it] {
+		if t == vt {
+			names = append(names, name)
+		}
+	}
+
+	if len(names) == 0 {
+		return ``, false
+	}
+
+	sort.Strings(names)
+	return names[0], true
+}